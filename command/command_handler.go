@@ -1,9 +1,12 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
 	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
@@ -15,38 +18,81 @@ type CommandHandlerImpl struct {
 }
 
 func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
-	if len(args) < 1 || command == "" {
+	// The tracker command runs with its defaults without arguments
+	if (len(args) < 1 && command != "tracker") || command == "" {
 		fmt.Println("Usage: mybittorrent <command> <args>")
 		return
 	}
 	switch command {
+	// $ ./your_bittorrent.sh create [-o out.torrent] [-a <tracker,...>]... [-c comment] [-piece-length n] [-private] <file|dir>
+	// example:
+	// $ ./your_bittorrent.sh create -o build.torrent -a http://tracker/announce -a udp://backup:6969 build/
+	case "create":
+		path, output, builder, err := parseCreateArgs(args)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			fmt.Println("Usage: mybittorrent create [-o out.torrent] [-a <tracker,...>]... [-c comment] [-created-by name] [-name name] [-piece-length n] [-private] [-no-date] <file|dir>")
+			return
+		}
+		torrent, err := Create(path, output, builder)
+		if err != nil {
+			fmt.Println("Error while creating torrent: ", err)
+			return
+		}
+		fmt.Printf("Created %s\nInfo Hash: %x\nPieces: %d of %d bytes\n", output, torrent.InfoHash, torrent.PieceCount(), torrent.PieceLength)
 	// $ ./your_bittorrent.sh decode <bencoded_string>
 	// example:
 	// $ ./your_bittorrent.sh decode d3:foo3:bar5:helloi52ee
+	// $ ./your_bittorrent.sh decode --strict <bencoded_string>
 	case "decode":
-		Decode(args[0])
+		if args[0] == "--strict" {
+			if len(args) < 2 {
+				fmt.Println("Usage: mybittorrent decode [--strict] <bencoded_string>")
+				return
+			}
+			Decode(args[1], true)
+			return
+		}
+		Decode(args[0], false)
+	// $ ./your_bittorrent.sh encode <json>
+	// $ ./your_bittorrent.sh encode -f <file.json>
+	// example:
+	// $ ./your_bittorrent.sh encode '{"foo":"bar","pieces":{"$hex":"ff00"}}'
+	case "encode":
+		input, err := readJSONInput(args)
+		if err != nil {
+			fmt.Println("Error while reading JSON input: ", err)
+			fmt.Println("Usage: mybittorrent encode <json> | -f <file.json>")
+			return
+		}
+		encoded, err := Encode(input)
+		if err != nil {
+			fmt.Println("Error while encoding: ", err)
+			return
+		}
+		os.Stdout.Write(encoded)
 	// $ ./your_bittorrent.sh download -o /tmp/test.txt sample.torrent
+	// $ ./your_bittorrent.sh download -o /tmp/test.txt <magnet_link>
+	// Multi-file torrents are written to the <output>/<name> directory
 	case "download":
 		if len(args) < 3 {
-			fmt.Println("Usage: mybittorrent download -o <output_dir> <torrent.file>")
+			fmt.Println("Usage: mybittorrent download -o <output_file|output_dir> <torrent.file|magnet_link>")
 			return
 		}
-		// Get the torrent file information
-		torrentFile := args[2]
-		torrent, err := OpenTorrentFile(torrentFile)
+		// Get the torrent information and the list of peers, from the trackers or the magnet link
+		torrent, tracker, peers, err := OpenTorrent(args[2])
 		if err != nil {
-			fmt.Println("Error while opening torrent file: ", err)
+			fmt.Println("Error while opening torrent: ", err)
 			return
 		}
-		// Get the list of peers from the tracker
-		peers, err := Peers(torrent.Announce, torrent.InfoHash, torrent.Length)
-		if err != nil {
-			return
+		if tracker != nil {
+			// Tell the tracker we are leaving once done
+			defer tracker.Stop()
 		}
 		outputFile := args[1]
 		// for debugging
 		fmt.Print(torrent.String())
-		err = Download(torrent, peers, outputFile)
+		err = Download(torrent, peers, outputFile, tracker)
 		if err != nil {
 			fmt.Println("Error while downloading torrent: ", err)
 			return
@@ -73,17 +119,18 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 			fmt.Println("Error while opening torrent file: ", err)
 			return
 		}
-		// Get the list of peers from the tracker
-		peers, err := Peers(torrent.Announce, torrent.InfoHash, torrent.Length)
+		// Get the list of peers from the trackers
+		peers, err := PeersFromTiers(NewTrackerTiers(torrent.Trackers()), torrent.InfoHash, torrent.Length)
 		if err != nil {
+			fmt.Println("Error while getting peers: ", err)
 			return
 		}
 		outputFile := args[1]
-		last := false
-		if pieceIndex == len(torrent.PieceHashes)-1 {
-			last = true
+		if pieceIndex < 0 || pieceIndex >= torrent.PieceCount() {
+			fmt.Printf("Invalid piece index: %d, the torrent has %d pieces\n", pieceIndex, torrent.PieceCount())
+			return
 		}
-		piece, err := DownloadPiece(peers[0], torrent.Length, torrent.PieceLength, torrent.InfoHash, torrent.PieceHashes[pieceIndex], pieceIndex, last)
+		piece, err := DownloadPiece(peers[0].String(), torrent, pieceIndex)
 		if err != nil {
 			fmt.Println("Error while downloading piece: ", err)
 			return
@@ -105,7 +152,7 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 			fmt.Println("Error while opening torrent file: ", err)
 			return
 		}
-		_, err = Handshake(torrentFile.InfoHash, peerAddr, false)
+		_, _, err = Handshake(torrentFile.InfoHash, peerAddr, false)
 		if err != nil {
 			fmt.Println("Error while handshaking with peer: ", err)
 			return
@@ -119,10 +166,22 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 		torrentFile := args[0]
 		// Print the torrent file information to pass the test
 		fmt.Print(Info(torrentFile))
+	// $ ./your_bittorrent.sh magnet sample.torrent
+	case "magnet":
+		if len(args) < 1 {
+			fmt.Println("Usage: mybittorrent magnet <torrent.file>")
+			return
+		}
+		torrent, err := OpenTorrentFile(args[0])
+		if err != nil {
+			fmt.Println("Error while opening torrent file: ", err)
+			return
+		}
+		fmt.Println(Magnet(torrent).Encode())
 	// $ ./your_bittorrent.sh magnet_handshake <magnet_link>
 	case "magnet_handshake":
 		if len(args) < 1 {
-			fmt.Println("Usage: mybittorrent magnet_handshake <torrent.file> <peer_ip:port>")
+			fmt.Println("Usage: mybittorrent magnet_handshake <magnet_link>")
 			return
 		}
 		magnetLink := args[0]
@@ -138,16 +197,38 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 		// 	return
 		// }
 		// fmt.Printf("Info hash converted: %s\n", string(infoHash))
-		peers, err := Peers(magnet.Tracker, magnet.InfoHash, 1996) // Passing an arbitrary length > 0
+		if len(magnet.Trackers) == 0 {
+			fmt.Println("The magnet link has no tracker")
+			return
+		}
+		// Every tr parameter is tried, the magnet trackers form a single tier
+		peers, err := PeersFromTiers(NewTrackerTiers([][]string{magnet.Trackers}), magnet.InfoHash, max(magnet.ExactLength, 1)) // Passing an arbitrary length > 0
 		if err != nil {
 			fmt.Println("Error while getting peers: ", err)
 			return
 		}
-		_, err = Handshake(magnet.InfoHash, peers[0], true)
+		if len(peers) == 0 {
+			fmt.Println("The tracker returned no peers")
+			return
+		}
+		conn, handshake, err := Handshake(magnet.InfoHash, peers[0].String(), true)
 		if err != nil {
 			fmt.Println("Error while handshaking with peer: ", err)
 			return
 		}
+		defer conn.Close()
+		if !handshake.SupportsExtensions() {
+			fmt.Println("The peer does not support extensions")
+			return
+		}
+		extensions := NewExtensions()
+		extensions.Register(d.UT_METADATA, ExtensionHandlerFunc(func(payload []byte) error { return nil }))
+		if _, err := extensions.Exchange(NewPeerWire(conn), 0); err != nil {
+			fmt.Println("Error while exchanging extension handshakes: ", err)
+			return
+		}
+		id, _ := extensions.PeerID(d.UT_METADATA)
+		fmt.Printf("Peer Metadata Extension ID: %d\n", id)
 	// $ ./your_bittorrent.sh magnet_parse <magnet_link>
 	case "magnet_parse":
 		if len(args) < 1 {
@@ -174,7 +255,7 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 			fmt.Println("Error while opening torrent file: ", err)
 			return
 		}
-		peers, err := Peers(torrent.Announce, torrent.InfoHash, torrent.Length)
+		peers, err := PeersFromTiers(NewTrackerTiers(torrent.Trackers()), torrent.InfoHash, torrent.Length)
 		if err != nil {
 			fmt.Println("Error while getting peers: ", err)
 			return
@@ -183,6 +264,79 @@ func (c *CommandHandlerImpl) HandleCommand(command string, args []string) {
 		for _, peer := range peers {
 			fmt.Println(peer)
 		}
+	// $ ./your_bittorrent.sh scrape [--json] <torrent.file|magnet_link>...
+	// example:
+	// $ ./your_bittorrent.sh scrape sample.torrent other.torrent
+	case "scrape":
+		asJSON := args[0] == "--json"
+		if asJSON {
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			fmt.Println("Usage: mybittorrent scrape [--json] <torrent.file|magnet_link>...")
+			return
+		}
+		results, err := ScrapeTorrents(args)
+		if err != nil {
+			fmt.Println("Error while scraping: ", err)
+			return
+		}
+		if !asJSON {
+			fmt.Print(FormatScrapeResults(results))
+			return
+		}
+		encoded, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Println("Error while encoding results: ", err)
+			return
+		}
+		fmt.Println(string(encoded))
+	// $ ./your_bittorrent.sh tracker [-addr :6969] [-interval 30m] [-trust-ip] [<torrent.file|info_hash>...]
+	// example:
+	// $ ./your_bittorrent.sh tracker -addr :8080 sample.torrent
+	case "tracker":
+		addr, server, err := parseTrackerArgs(args)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			fmt.Println("Usage: mybittorrent tracker [-addr :6969] [-interval 30m] [-trust-ip] [<torrent.file|info_hash>...]")
+			return
+		}
+		fmt.Printf("Tracker listening on %s for %s\n", addr, formatAllowed(server.Allowed))
+		if err := ServeTracker(addr, server); err != nil {
+			fmt.Println("Error while running the tracker: ", err)
+		}
+	// $ ./your_bittorrent.sh verify [--json] <torrent.file> <output_file|output_dir>
+	// example:
+	// $ ./your_bittorrent.sh verify sample.torrent /tmp/test.txt
+	case "verify":
+		asJSON := args[0] == "--json"
+		if asJSON {
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			fmt.Println("Usage: mybittorrent verify [--json] <torrent.file> <output_file|output_dir>")
+			return
+		}
+		torrent, err := OpenTorrentFile(args[0])
+		if err != nil {
+			fmt.Println("Error while opening torrent file: ", err)
+			return
+		}
+		report, err := Verify(torrent, args[1])
+		if err != nil {
+			fmt.Println("Error while verifying torrent data: ", err)
+			return
+		}
+		if !asJSON {
+			fmt.Print(report.String())
+			return
+		}
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Println("Error while encoding report: ", err)
+			return
+		}
+		fmt.Println(string(encoded))
 	default:
 		fmt.Println("Unknown command: " + command)
 	}
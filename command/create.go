@@ -0,0 +1,97 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+const CREATED_BY = "mybittorrent"
+
+// Create hashes the file or directory at path and writes the resulting .torrent file to output
+func Create(path, output string, builder *encoder.MetaInfoBuilder) (*decoder.TorrentFile, error) {
+	content, err := builder.Build(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while building the torrent: %v", err)
+	}
+	// Decode what we built, both to validate it and to report its info hash
+	// Torrents without trackers are valid, their peers come from the DHT or web seeds
+	var meta decoder.MetaInfo
+	if err := decoder.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("error while checking the torrent: %v", err)
+	}
+	torrent, err := decoder.TorrentFileFromMetaInfo(&meta)
+	if err != nil {
+		return nil, fmt.Errorf("error while checking the torrent: %v", err)
+	}
+	if err := utils.WriteFile(output, content); err != nil {
+		return nil, err
+	}
+	return torrent, nil
+}
+
+// Parse the arguments of the create command into the path to hash, the output file and the builder options
+func parseCreateArgs(args []string) (path, output string, builder *encoder.MetaInfoBuilder, err error) {
+	builder = &encoder.MetaInfoBuilder{}
+	var tiers trackerTierFlag
+	var noDate bool
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.StringVar(&output, "o", "", "output .torrent file, defaults to <name>.torrent")
+	flags.Var(&tiers, "a", "tracker tier as comma separated URLs, repeat for more tiers")
+	flags.StringVar(&builder.Comment, "c", "", "comment")
+	flags.StringVar(&builder.CreatedBy, "created-by", CREATED_BY, "created by")
+	flags.StringVar(&builder.Name, "name", "", "torrent name, defaults to the base name of the path")
+	flags.IntVar(&builder.PieceLength, "piece-length", 0, "piece length in bytes, chosen from the total size when 0")
+	flags.BoolVar(&builder.Private, "private", false, "set the private flag")
+	flags.BoolVar(&noDate, "no-date", false, "leave out the creation date")
+	if err := flags.Parse(args); err != nil {
+		return "", "", nil, err
+	}
+	if flags.NArg() != 1 {
+		return "", "", nil, fmt.Errorf("expected exactly one file or directory, got %d", flags.NArg())
+	}
+	path = flags.Arg(0)
+	if len(tiers) > 0 {
+		builder.Announce = tiers[0][0]
+	}
+	if len(tiers) > 1 || (len(tiers) == 1 && len(tiers[0]) > 1) {
+		builder.AnnounceList = tiers
+	}
+	if !noDate {
+		builder.CreationDate = time.Now()
+	}
+	if output == "" {
+		name := builder.Name
+		if name == "" {
+			name = path
+		}
+		output = strings.TrimRight(name, "/") + ".torrent"
+	}
+	return path, output, builder, nil
+}
+
+// A repeatable flag where each occurrence is a tier of comma separated tracker URLs
+type trackerTierFlag [][]string
+
+func (f *trackerTierFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *trackerTierFlag) Set(value string) error {
+	tier := []string{}
+	for _, u := range strings.Split(value, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			tier = append(tier, u)
+		}
+	}
+	if len(tier) == 0 {
+		return fmt.Errorf("empty tracker tier")
+	}
+	*f = append(*f, tier)
+	return nil
+}
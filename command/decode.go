@@ -1,23 +1,99 @@
 package command
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
 )
 
-func Decode(value string) {
-	decoded, _, err := decoder.DecodeBencode(value)
+// Byte strings and dictionaries that JSON cannot hold as is are wrapped in a single key object:
+// - {"$hex": "<hex>"} is a byte string that is not valid UTF-8
+// - {"$dict": [[<key>, <value>], ...]} is a dictionary with a non UTF-8 key, or whose only key is a marker
+const (
+	HEX_MARKER  = "$hex"
+	DICT_MARKER = "$dict"
+)
+
+// Decode prints a bencoded value as JSON, in strict mode non-canonical encodings are rejected
+func Decode(value string, strict bool) {
+	d := decoder.NewBencodeDecoder(strings.NewReader(value))
+	d.SetStrict(strict)
+	d.UseByteStrings()
+	d.UseInt64()
+	decoded, err := d.Decode()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if strict && d.Offset() != int64(len(value)) {
+		fmt.Printf("unexpected data after the bencoded value at offset %d\n", d.Offset())
+		return
+	}
+
+	jsonOutput, err := json.Marshal(bencodeToJSON(decoded))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Println(string(jsonOutput))
+}
+
+// Convert a decoded bencode value into a value that encoding/json renders without losing data
+func bencodeToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case decoder.ByteString:
+		if v.IsText() {
+			return string(v)
+		}
+		return map[string]string{HEX_MARKER: hex.EncodeToString(v)}
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			list = append(list, bencodeToJSON(element))
+		}
+		return list
+	case map[string]interface{}:
+		if !isPlainDictionary(v) {
+			pairs := make([][]interface{}, 0, len(v))
+			for _, k := range sortedKeys(v) {
+				pairs = append(pairs, []interface{}{bencodeToJSON(decoder.ByteString(k)), bencodeToJSON(v[k])})
+			}
+			return map[string]interface{}{DICT_MARKER: pairs}
+		}
+		dict := make(map[string]interface{}, len(v))
+		for k, element := range v {
+			dict[k] = bencodeToJSON(element)
+		}
+		return dict
+	default:
+		// int64 and *big.Int are rendered as JSON numbers
+		return v
+	}
+}
+
+// A dictionary can be a JSON object if its keys are text and it cannot be mistaken for a marker
+func isPlainDictionary(dict map[string]interface{}) bool {
+	for k := range dict {
+		if !utf8.ValidString(k) {
+			return false
+		}
+		if len(dict) == 1 && (k == HEX_MARKER || k == DICT_MARKER) {
+			return false
+		}
+	}
+	return true
+}
 
-	jsonOutput, _ := json.Marshal(decoded)
-	if string(jsonOutput) == "null" {
-		fmt.Println("[]")
-	} else {
-		fmt.Println(string(jsonOutput))
+func sortedKeys(dict map[string]interface{}) []string {
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
 	}
+	slices.Sort(keys)
+	return keys
 }
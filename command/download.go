@@ -2,7 +2,10 @@ package command
 
 import (
 	"fmt"
-	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,71 +13,189 @@ import (
 	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
+const (
+	MAX_PEER_WORKERS   = 20              // Peers downloaded from at the same time
+	PEERS_WAIT_TIMEOUT = 5 * time.Minute // Time a worker waits for the tracker to return new peers once every peer was tried
+)
+
 // Download downloads a torrent file from a list of peers concurrently
-func Download(t *d.TorrentFile, peers []string, outputFile string) error {
+// Each peer worker holds a single connection and downloads the pieces its peer has from a shared queue,
+// keeping a pipeline of requests sized to the peer's throughput and latency across piece boundaries,
+// a worker whose peer fails puts its pieces back and moves on to the next peer
+// Single-file torrents are written to outputFile, multi-file torrents to the outputFile/<name> directory
+// The transfer stats are reported to the tracker session, which may be nil, and the peers of its re-announces
+// are added to the download; once every peer was tried, the workers ask the tracker for more
+// A peer that failed is tried again when a re-announce returns it after PeerRetryBackoff
+func Download(t *d.TorrentFile, peers []string, outputFile string, tracker *TrackerSession) error {
 	// Time the execution of the function
 	start := time.Now()
 	fmt.Printf("List of peers: %v\n", peers)
-	data := make(map[int][]byte) // Map to store the piece data, key is the piece index
-	var mu sync.Mutex            // Mutex to protect the data map
-	var wg sync.WaitGroup        // WaitGroup to wait for all goroutines to complete
-	results := make(chan error, len(t.PieceHashes))
+	pieces := make([]int, t.PieceCount())
+	for i := range pieces {
+		pieces[i] = i
+	}
+	queue := newPieceQueue(pieces)
+	data := make([][]byte, t.PieceCount()) // The piece data, indexed by piece
+	var mu sync.Mutex                      // Mutex to protect data and left
+	left := t.Length                       // Bytes still to download
+	store := func(pieceIndex int, pieceData []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		data[pieceIndex] = pieceData
+		left -= len(pieceData)
+		if tracker != nil {
+			tracker.AddDownloaded(len(pieceData))
+			tracker.SetLeft(left)
+		}
+	}
 
-	// Download each piece concurrently
-	for pIndex, pieceHash := range t.PieceHashes {
+	// Workers take the next peer when theirs fails
+	pool := newPeerPool(peers)
+	workers := min(MAX_PEER_WORKERS, len(peers))
+	if tracker != nil {
+		tracker.SetOnPeers(func(peers []d.Peer) { pool.add(PeerAddrs(peers)) })
+		defer tracker.SetOnPeers(nil)
+		workers = MAX_PEER_WORKERS
+	}
+	var wg sync.WaitGroup // WaitGroup to wait for all workers to complete
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(pIndex int, pieceHash string) {
+		go func() {
 			defer wg.Done()
-			// Shuffle the peers slice to select a random peer
-			shuffledPeers := make([]string, len(peers))
-			copy(shuffledPeers, peers)
-			rand.Shuffle(len(shuffledPeers), func(i, j int) {
-				shuffledPeers[i], shuffledPeers[j] = shuffledPeers[j], shuffledPeers[i]
-			})
-			for _, peer := range shuffledPeers {
-				pieceData, err := DownloadPiece(peer, t.Length, t.PieceLength, t.InfoHash, pieceHash, pIndex, pIndex == len(t.PieceHashes)-1)
-				if err != nil { // If there is an error while downloading the piece, try the next peer
-					fmt.Printf("error while downloading piece %d with peer %s, moving on\n", pIndex, peer)
+			var deadline <-chan time.Time // Set once the worker runs out of peers
+			for {
+				// Taken before checking, so that the last piece completing cannot be missed
+				changed := queue.changes()
+				if queue.done() {
+					return
+				}
+				peer, ok, added := pool.next()
+				if !ok {
+					if tracker == nil {
+						return
+					}
+					if deadline == nil {
+						deadline = time.After(PEERS_WAIT_TIMEOUT)
+					}
+					// Asked again every PeerRetryBackoff, for the failed peers to be tried again
+					tracker.Reannounce()
+					select {
+					case <-added:
+					case <-changed:
+					case <-time.After(PeerRetryBackoff):
+					case <-deadline:
+						return
+					}
 					continue
 				}
-				mu.Lock()
-				data[pIndex] = pieceData // Store the piece data that was downloaded successfully
-				mu.Unlock()
-				fmt.Printf("successfully downloaded piece %d with peer %s\n", pIndex, peer)
-				results <- nil
-				return
+				deadline = nil
+				if err := downloadFromPeer(peer, t, queue, store); err != nil {
+					fmt.Printf("error while downloading with peer %s, moving on: %v\n", peer, err)
+					pool.failed(peer)
+				}
 			}
-			results <- fmt.Errorf("error while downloading piece %d, no peers succeeded", pIndex)
-		}(pIndex, pieceHash)
+		}()
 	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	for err := range results {
-		if err != nil {
-			return err
-		}
+	wg.Wait()
+	if !queue.done() {
+		return fmt.Errorf("error while downloading the torrent, no peers succeeded for %d pieces", queue.remaining())
 	}
 
-	dataReconstructed := make([]byte, 0)
-	for i := 0; i < len(t.PieceHashes); i++ {
-		if d, ok := data[i]; !ok {
-			return fmt.Errorf("error while downloading piece %d, piece data not found", i)
-		} else {
-			dataReconstructed = append(dataReconstructed, d...)
-		}
+	dataReconstructed := make([]byte, 0, t.Length)
+	for _, d := range data {
+		dataReconstructed = append(dataReconstructed, d...)
 	}
 
-	err := utils.WriteFile(outputFile, dataReconstructed)
+	err := writeTorrentData(t, outputFile, dataReconstructed)
 	if err != nil {
 		return fmt.Errorf("error while writing to file: %v", err)
 	}
+	if tracker != nil {
+		if err := tracker.Completed(); err != nil {
+			fmt.Printf("error while sending the completed event: %v\n", err)
+		}
+	}
 	// Print the time taken to download the torrent
 	// Convert the length from byte to megabyte
 	torrentsize := float64(t.Length) / float64(1_000_000)
 	fmt.Printf("Downloaded torrent of size %5fmb in %v\n", torrentsize, time.Since(start))
 	return nil
 }
+
+// Download pieces from a peer over a single connection, until every piece is downloaded or the peer fails
+func downloadFromPeer(peerAddr string, t *d.TorrentFile, queue *pieceQueue, store func(pieceIndex int, pieceData []byte)) error {
+	c, err := DialPeer(t.InfoHash, peerAddr, t.PieceCount())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.SetInterested(true); err != nil {
+		return err
+	}
+	return pipelinePieces(c, t, queue, store)
+}
+
+// OpenTorrent opens a torrent file or a magnet link and returns the torrent along with the peers to download it from
+// The torrent is announced to its trackers with a started tracker session, nil when it has no trackers
+func OpenTorrent(torrentOrMagnet string) (*d.TorrentFile, *TrackerSession, []string, error) {
+	var torrent *d.TorrentFile
+	var peers []string
+	var err error
+	if strings.HasPrefix(torrentOrMagnet, "magnet:") {
+		// Magnet peers are needed to get the info dictionary, the session starts once its length is known
+		torrent, peers, err = TorrentFromMagnet(torrentOrMagnet)
+	} else {
+		torrent, err = OpenTorrentFile(torrentOrMagnet)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(torrent.Trackers()) == 0 {
+		return torrent, nil, peers, nil
+	}
+	session := NewTrackerSession(NewTrackerTiers(torrent.Trackers()), torrent.InfoHash, torrent.Length)
+	trackerPeers, err := session.Start()
+	if err != nil && len(peers) == 0 {
+		return nil, nil, nil, err
+	}
+	if err != nil {
+		return torrent, nil, peers, nil
+	}
+	for _, peer := range PeerAddrs(trackerPeers) {
+		if !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	if len(peers) == 0 {
+		session.Stop()
+		return nil, nil, nil, fmt.Errorf("the trackers returned no peers")
+	}
+	return torrent, session, peers, nil
+}
+
+// Write the downloaded data to disk, splitting it into the torrent's files for multi-file torrents
+func writeTorrentData(t *d.TorrentFile, output string, data []byte) error {
+	if !t.MultiFile {
+		return utils.WriteFile(output, data)
+	}
+	root, err := utils.SafeJoin(output, t.Name)
+	if err != nil {
+		return err
+	}
+	for _, f := range t.Files {
+		if f.Padding {
+			continue
+		}
+		path, err := utils.SafeJoin(root, f.Path...)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("error while creating directory for %s: %v", path, err)
+		}
+		if err := utils.WriteFile(path, data[f.Offset:f.Offset+f.Length]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+// Encode converts a JSON value into canonical bencode
+// It is the reverse of Decode: byte strings and dictionaries wrapped in HEX_MARKER and DICT_MARKER objects are unwrapped
+func Encode(jsonInput []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(jsonInput))
+	d.UseNumber()
+	var value interface{}
+	if err := d.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error decoding JSON: %v", err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("error decoding JSON: unexpected data after the top-level value")
+	}
+	converted, err := jsonToBencode(value)
+	if err != nil {
+		return nil, err
+	}
+	return encoder.Marshal(converted)
+}
+
+// Convert a value decoded by encoding/json into a value the encoder package can bencode
+func jsonToBencode(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		n, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("bencode only supports integers, got %s", v)
+		}
+		if n.IsInt64() {
+			return n.Int64(), nil
+		}
+		return n, nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			converted, err := jsonToBencode(element)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if h, ok := v[HEX_MARKER]; ok {
+				return hexToBytes(h)
+			}
+			if pairs, ok := v[DICT_MARKER]; ok {
+				return pairsToDictionary(pairs)
+			}
+		}
+		dict := make(map[string]interface{}, len(v))
+		for k, element := range v {
+			converted, err := jsonToBencode(element)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", k, err)
+			}
+			dict[k] = converted
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("bencode has no equivalent for the JSON value %v", v)
+	}
+}
+
+func hexToBytes(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a hex string, got %v", HEX_MARKER, value)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", HEX_MARKER, s, err)
+	}
+	return b, nil
+}
+
+// Build a dictionary from a list of [key, value] pairs, keys are strings or HEX_MARKER objects
+func pairsToDictionary(value interface{}) (map[string]interface{}, error) {
+	pairs, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s expects a list of [key, value] pairs, got %v", DICT_MARKER, value)
+	}
+	dict := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		pair, ok := p.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("%s expects a list of [key, value] pairs, got %v", DICT_MARKER, p)
+		}
+		key, err := jsonToBencode(pair[0])
+		if err != nil {
+			return nil, err
+		}
+		var k string
+		switch key := key.(type) {
+		case string:
+			k = key
+		case []byte:
+			k = string(key)
+		default:
+			return nil, fmt.Errorf("dictionary keys must be strings, got %v", pair[0])
+		}
+		if _, ok := dict[k]; ok {
+			return nil, fmt.Errorf("duplicate dictionary key %q", k)
+		}
+		element, err := jsonToBencode(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", k, err)
+		}
+		dict[k] = element
+	}
+	return dict, nil
+}
+
+// Read the JSON input of the encode command, either inline or from a file
+func readJSONInput(args []string) ([]byte, error) {
+	if args[0] != "-f" {
+		return []byte(args[0]), nil
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing JSON file")
+	}
+	content, err := utils.ReadFile(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
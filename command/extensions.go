@@ -0,0 +1,177 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+const (
+	CLIENT_VERSION = "mybittorrent 1.0" // Sent as v in the extension handshake
+	LOCAL_REQQ     = 250                // Number of outstanding requests we accept from a peer, sent as reqq
+)
+
+// ExtensionHandler handles the extended messages of an extension, payload excludes the extended message id
+type ExtensionHandler interface {
+	HandleExtendedMessage(payload []byte) error
+}
+
+// ExtensionHandlerFunc adapts a function to the ExtensionHandler interface
+type ExtensionHandlerFunc func(payload []byte) error
+
+func (f ExtensionHandlerFunc) HandleExtendedMessage(payload []byte) error {
+	return f(payload)
+}
+
+// Extensions is the registry of the extensions (BEP 10) supported on a connection
+// Each registered extension gets a local extended message id, advertised in the m dictionary of our handshake,
+// the peer tells us in its own handshake which ids to use when we send it messages
+type Extensions struct {
+	mu       sync.Mutex
+	local    map[string]uint8 // Extension name -> id the peer must use to send us its messages
+	handlers map[uint8]ExtensionHandler
+	peer     *d.ExtendedHandshake // Last extension handshake received from the peer
+}
+
+func NewExtensions() *Extensions {
+	return &Extensions{local: map[string]uint8{}, handlers: map[uint8]ExtensionHandler{}}
+}
+
+// Register adds an extension, such as ut_metadata or ut_pex, and returns its local extended message id
+func (e *Extensions) Register(name string, handler ExtensionHandler) (uint8, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.local[name]; ok {
+		return 0, fmt.Errorf("extension %s is already registered", name)
+	}
+	if len(e.local) == 255 {
+		return 0, fmt.Errorf("too many extensions")
+	}
+	id := uint8(len(e.local) + 1) // 0 is the extension handshake
+	e.local[name] = id
+	e.handlers[id] = handler
+	return id, nil
+}
+
+// Handshake returns our extension handshake, metadataSize is the size of the info dictionary we can send, 0 if unknown
+func (e *Extensions) Handshake(metadataSize int, peerAddr net.Addr) *d.ExtendedHandshake {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m := make(map[string]int, len(e.local))
+	for name, id := range e.local {
+		m[name] = int(id)
+	}
+	return &d.ExtendedHandshake{
+		M:            m,
+		MetadataSize: metadataSize,
+		V:            CLIENT_VERSION,
+		YourIP:       compactIP(peerAddr),
+		Reqq:         LOCAL_REQQ,
+	}
+}
+
+// Exchange sends our extension handshake and waits for the peer's, other messages received meanwhile are dropped
+// The peer must have set the extension bit of its handshake
+func (e *Extensions) Exchange(wire *PeerWire, metadataSize int) (*d.ExtendedHandshake, error) {
+	ours, err := d.ExtendedHandshakeMessage(e.Handshake(metadataSize, wire.Conn().RemoteAddr()))
+	if err != nil {
+		return nil, err
+	}
+	if err := wire.WriteMessage(ours); err != nil {
+		return nil, fmt.Errorf("error while sending the extension handshake: %v", err)
+	}
+	for {
+		pm, err := wire.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the extension handshake: %v", err)
+		}
+		if pm.Id != d.EXTENDED || pm.Payload[0] != d.EXTENDED_HANDSHAKE_ID {
+			continue
+		}
+		if err := e.Dispatch(pm); err != nil {
+			return nil, err
+		}
+		return e.Peer(), nil
+	}
+}
+
+// Dispatch handles an EXTENDED message: handshakes update what the peer supports, other messages go to the handler
+// of the extension whose local id they carry
+func (e *Extensions) Dispatch(pm *d.PeerMessage) error {
+	id, payload, err := d.DecodeExtendedMessage(pm)
+	if err != nil {
+		return err
+	}
+	if id == d.EXTENDED_HANDSHAKE_ID {
+		h, err := d.DecodeExtendedHandshake(payload)
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		// Later handshakes only update the extensions they mention, an id of 0 disables an extension
+		if e.peer != nil {
+			if h.M == nil {
+				h.M = map[string]int{}
+			}
+			for name, peerID := range e.peer.M {
+				if _, ok := h.M[name]; !ok {
+					h.M[name] = peerID
+				}
+			}
+		}
+		e.peer = h
+		e.mu.Unlock()
+		return nil
+	}
+	e.mu.Lock()
+	handler, ok := e.handlers[id]
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("received an extended message with the unknown id %d", id)
+	}
+	return handler.HandleExtendedMessage(payload)
+}
+
+// Peer returns the peer's extension handshake, nil until it is received
+func (e *Extensions) Peer() *d.ExtendedHandshake {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.peer
+}
+
+// PeerID returns the extended message id the peer assigned to an extension, false if it does not support it
+func (e *Extensions) PeerID(name string) (uint8, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.peer == nil {
+		return 0, false
+	}
+	id, ok := e.peer.M[name]
+	if !ok || id <= 0 || id > 255 {
+		return 0, false
+	}
+	return uint8(id), true
+}
+
+// Message wraps the payload of an extension into an EXTENDED message the peer understands
+func (e *Extensions) Message(name string, payload []byte) (*d.PeerMessage, error) {
+	id, ok := e.PeerID(name)
+	if !ok {
+		return nil, fmt.Errorf("the peer does not support %s", name)
+	}
+	return d.ExtendedMessage(id, payload), nil
+}
+
+// The compact form of the IP address of addr, 4 bytes for IPv4 and 16 for IPv6
+func compactIP(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	if ip := tcpAddr.IP.To4(); ip != nil {
+		return string(ip)
+	}
+	return string(tcpAddr.IP.To16())
+}
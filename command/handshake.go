@@ -2,37 +2,49 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"time"
 
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
 	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
-	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
+const HANDSHAKE_TIMEOUT = 10 * time.Second // Time a peer has to accept the connection, and then to complete the handshake
+
 // Handshake performs a handshake with a peer, given the torrent info hash and the peer address
-func Handshake(torrentInfoHash, peerAddr string, extended bool) (net.Conn, error) {
+// It returns the connection along with the peer's handshake, which must be for the same info hash
+func Handshake(torrentInfoHash, peerAddr string, extended bool) (net.Conn, *d.HandshakeMessage, error) {
 	fmt.Println("Handshaking with peer: " + peerAddr)
-	// Generate random peer ID
-	peerID := utils.GeneratePeerID()
-	handshakeMessage := []byte{}
-	if !extended {
-		handshakeMessage = encoder.MakeHandshakeMessage(torrentInfoHash, peerID, false)
-	} else {
-		handshakeMessage = encoder.MakeHandshakeMessage(torrentInfoHash, peerID, true)
-	}
-	conn, err := net.Dial("tcp", peerAddr)
+	// Use the same peer ID as with the trackers
+	handshakeMessage := encoder.MakeHandshakeMessage(torrentInfoHash, PeerID, extended)
+	conn, err := net.DialTimeout("tcp", peerAddr, HANDSHAKE_TIMEOUT)
 	if err != nil {
-		return nil, fmt.Errorf("Error connecting to peer: " + err.Error())
+		return nil, nil, fmt.Errorf("Error connecting to peer: " + err.Error())
 	}
+	// A peer that accepts connections but never answers must not hold the caller up
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
 	_, err = conn.Write(handshakeMessage)
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("Error sending handshake to peer: " + err.Error())
+		return nil, nil, fmt.Errorf("Error sending handshake to peer: " + err.Error())
 	}
-	_, err = conn.Read(handshakeMessage)
+	// The handshake may arrive in several reads
+	_, err = io.ReadFull(conn, handshakeMessage)
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("Error receiving handshake message from peer: " + err.Error())
+		return nil, nil, fmt.Errorf("Error receiving handshake message from peer: " + err.Error())
+	}
+	handshake, err := d.DecodeHandshakeMessage(handshakeMessage)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("Error decoding handshake message from peer: " + err.Error())
+	}
+	if handshake.InfoHash != torrentInfoHash {
+		conn.Close()
+		return nil, nil, fmt.Errorf("peer %s answered with info hash %x", peerAddr, handshake.InfoHash)
 	}
-	fmt.Printf("Peer ID: %x\n", handshakeMessage[48:])
-	return conn, nil
+	conn.SetDeadline(time.Time{})
+	fmt.Printf("Peer ID: %x\n", handshake.PeerID)
+	return conn, handshake, nil
 }
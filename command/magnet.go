@@ -0,0 +1,31 @@
+package command
+
+import (
+	"slices"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+// Magnet builds the magnet link of a torrent with every tracker and web seed it lists
+func Magnet(t *d.TorrentFile) *d.MagnetLink {
+	magnet := &d.MagnetLink{
+		InfoHash:    t.InfoHash,
+		InfoHashV2:  t.InfoHashV2,
+		DisplayName: t.Name,
+		WebSeeds:    t.WebSeeds,
+		ExactLength: t.Length,
+	}
+	// The announce URL comes first, even when the announce-list takes precedence over it and does not repeat it
+	trackers := slices.Concat([][]string{{t.Announce}}, t.Trackers())
+	for _, tier := range trackers {
+		for _, tracker := range tier {
+			if tracker != "" && !slices.Contains(magnet.Trackers, tracker) {
+				magnet.Trackers = append(magnet.Trackers, tracker)
+			}
+		}
+	}
+	if len(magnet.Trackers) > 0 {
+		magnet.Tracker = magnet.Trackers[0]
+	}
+	return magnet
+}
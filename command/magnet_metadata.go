@@ -0,0 +1,284 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+const (
+	METADATA_PEERS   = 5                // Number of peers the info dictionary is fetched from at the same time
+	METADATA_TIMEOUT = 10 * time.Second // Time a peer has to answer each message
+)
+
+// TorrentFromMagnet fetches the info dictionary of a magnet link from peers and returns the torrent along with its peers
+// Peers come from the magnet's trackers and its x.pe parameters
+func TorrentFromMagnet(link string) (*d.TorrentFile, []string, error) {
+	magnet, err := MagnetParse(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while parsing magnet link: %v", err)
+	}
+	peers := append([]string{}, magnet.Peers...)
+	if len(magnet.Trackers) > 0 {
+		// The length is unknown until we have the info dictionary, trackers only need it to be positive
+		trackerPeers, err := PeersFromTiers(NewTrackerTiers([][]string{magnet.Trackers}), magnet.InfoHash, max(magnet.ExactLength, 1))
+		if err != nil && len(peers) == 0 {
+			return nil, nil, err
+		}
+		peers = append(peers, PeerAddrs(trackerPeers)...)
+	}
+	if len(peers) == 0 {
+		return nil, nil, fmt.Errorf("the magnet link has neither trackers nor peers")
+	}
+
+	info, err := FetchMetadata(magnet, peers)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := &d.MetaInfo{Info: info}
+	if len(magnet.Trackers) > 0 {
+		meta.Announce = magnet.Trackers[0]
+		meta.AnnounceList = [][]string{magnet.Trackers}
+	}
+	torrent, err := d.TorrentFileFromMetaInfo(meta)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while decoding the info dictionary: %v", err)
+	}
+	torrent.WebSeeds = magnet.WebSeeds
+	return torrent, peers, nil
+}
+
+// FetchMetadata downloads the info dictionary of a magnet link with the ut_metadata extension (BEP 9)
+// Its pieces are spread over up to METADATA_PEERS peers and the result is checked against the magnet's info hashes
+func FetchMetadata(magnet *d.MagnetLink, peers []string) ([]byte, error) {
+	f := &metadataFetcher{magnet: magnet, candidates: map[int]*metadataCandidate{}, rejected: map[int]bool{}, done: make(chan struct{})}
+	addrs := make(chan string, len(peers))
+	for _, peer := range peers {
+		addrs <- peer
+	}
+	close(addrs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(METADATA_PEERS, len(peers)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// When a peer fails, move on to the next one
+			for peer := range addrs {
+				select {
+				case <-f.done:
+					return
+				default:
+				}
+				if err := f.fetchFrom(peer); err != nil {
+					fmt.Printf("error while fetching metadata from peer %s: %v\n", peer, err)
+				}
+			}
+		}()
+	}
+	exited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-f.done:
+	case <-exited:
+		select {
+		case <-f.done:
+		default:
+			return nil, fmt.Errorf("could not fetch the metadata from any of the %d peers", len(peers))
+		}
+	}
+	return f.info, nil
+}
+
+// The pieces of an info dictionary being fetched from several peers
+// Peers that disagree on the metadata size are fetched from separately, so that a peer lying about it
+// cannot make the honest ones fail; a size whose metadata does not match the info hash is rejected
+type metadataFetcher struct {
+	magnet     *d.MagnetLink
+	mu         sync.Mutex
+	candidates map[int]*metadataCandidate // By metadata size
+	rejected   map[int]bool
+	info       []byte        // The verified info dictionary, once done is closed
+	done       chan struct{} // Closed once the info dictionary is received and verified
+}
+
+// The pieces of an info dictionary of a given size, pieces are only allocated once received
+type metadataCandidate struct {
+	size     int
+	pieces   [][]byte
+	received int
+	pending  chan int      // Indices of the pieces still to request
+	rejected chan struct{} // Closed when the metadata does not match the info hash
+}
+
+// Return the pieces being fetched for the metadata size a peer announced, the size must be bounded by the caller
+func (f *metadataFetcher) candidate(size int) (*metadataCandidate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rejected[size] {
+		return nil, fmt.Errorf("the metadata of %d bytes announced by the peer does not match the info hash", size)
+	}
+	if c, ok := f.candidates[size]; ok {
+		return c, nil
+	}
+	numPieces := (size + d.METADATA_PIECE_LENGTH - 1) / d.METADATA_PIECE_LENGTH
+	c := &metadataCandidate{size: size, pieces: make([][]byte, numPieces), pending: make(chan int, numPieces), rejected: make(chan struct{})}
+	for i := 0; i < numPieces; i++ {
+		c.pending <- i
+	}
+	f.candidates[size] = c
+	return c, nil
+}
+
+// Store a piece, and check the metadata against the info hashes once every piece is received
+func (f *metadataFetcher) store(c *metadataCandidate, piece int, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c.pieces[piece] != nil || f.rejected[c.size] {
+		return
+	}
+	c.pieces[piece] = data
+	c.received++
+	if c.received < len(c.pieces) {
+		return
+	}
+	info := bytes.Join(c.pieces, nil)
+	if err := verifyMetadata(f.magnet, info); err != nil {
+		fmt.Printf("error while checking the metadata of %d bytes: %v\n", c.size, err)
+		f.rejected[c.size] = true
+		delete(f.candidates, c.size)
+		close(c.rejected)
+		return
+	}
+	select {
+	case <-f.done:
+	default:
+		f.info = info
+		close(f.done)
+	}
+}
+
+// Request pieces from a peer until every piece is received, a piece that the peer fails to send is put back for the others
+func (f *metadataFetcher) fetchFrom(peer string) error {
+	conn, handshake, err := Handshake(f.magnet.InfoHash, peer, true)
+	if err != nil {
+		return err
+	}
+	wire := NewPeerWire(conn)
+	wire.Timeout = METADATA_TIMEOUT
+	defer wire.Close()
+	if !handshake.SupportsExtensions() {
+		return fmt.Errorf("the peer does not support extensions")
+	}
+	p := &metadataPeer{wire: wire, extensions: NewExtensions()}
+	if _, err := p.extensions.Register(d.UT_METADATA, ExtensionHandlerFunc(p.handleMetadata)); err != nil {
+		return err
+	}
+	theirs, err := p.extensions.Exchange(wire, 0)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.extensions.PeerID(d.UT_METADATA); !ok {
+		return fmt.Errorf("the peer does not support %s", d.UT_METADATA)
+	}
+	// Bounded before anything is allocated for it
+	if theirs.MetadataSize <= 0 || theirs.MetadataSize > d.MAX_METADATA_SIZE {
+		return fmt.Errorf("invalid metadata size %d", theirs.MetadataSize)
+	}
+	c, err := f.candidate(theirs.MetadataSize)
+	if err != nil {
+		return err
+	}
+	for {
+		var piece int
+		select {
+		case <-f.done:
+			return nil
+		case <-c.rejected:
+			return fmt.Errorf("the metadata of %d bytes does not match the info hash", c.size)
+		case piece = <-c.pending:
+		}
+		data, err := p.requestPiece(piece, min(d.METADATA_PIECE_LENGTH, c.size-piece*d.METADATA_PIECE_LENGTH))
+		if err != nil {
+			c.pending <- piece
+			return fmt.Errorf("error while fetching metadata piece %d: %v", piece, err)
+		}
+		f.store(c, piece, data)
+	}
+}
+
+// A connection to a peer we request metadata pieces from, one at a time
+type metadataPeer struct {
+	wire       *PeerWire
+	extensions *Extensions
+	response   *d.MetadataMessage // Last ut_metadata message received
+	data       []byte
+}
+
+func (p *metadataPeer) handleMetadata(payload []byte) error {
+	m, data, err := d.DecodeMetadataMessage(payload)
+	if err != nil {
+		return err
+	}
+	p.response, p.data = m, data
+	return nil
+}
+
+func (p *metadataPeer) requestPiece(piece, length int) ([]byte, error) {
+	payload, err := d.EncodeMetadataMessage(&d.MetadataMessage{MsgType: d.METADATA_REQUEST, Piece: piece}, nil)
+	if err != nil {
+		return nil, err
+	}
+	request, err := p.extensions.Message(d.UT_METADATA, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.wire.WriteMessage(request); err != nil {
+		return nil, err
+	}
+	for {
+		pm, err := p.wire.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if pm.Id != d.EXTENDED {
+			continue
+		}
+		p.response = nil
+		if err := p.extensions.Dispatch(pm); err != nil {
+			return nil, err
+		}
+		if p.response == nil || p.response.Piece != piece {
+			continue
+		}
+		switch p.response.MsgType {
+		case d.METADATA_DATA:
+			if len(p.data) != length {
+				return nil, fmt.Errorf("received %d bytes, expected %d", len(p.data), length)
+			}
+			return p.data, nil
+		case d.METADATA_REJECT:
+			return nil, fmt.Errorf("the peer rejected the request")
+		}
+	}
+}
+
+// Check the info dictionary against the v1 info hash, and the v2 info hash when the magnet has one
+func verifyMetadata(magnet *d.MagnetLink, info []byte) error {
+	v2Only := magnet.InfoHashV2 != "" && magnet.InfoHash == magnet.InfoHashV2[:20]
+	if !v2Only && utils.SHA1Hash(info) != magnet.InfoHash {
+		return fmt.Errorf("the metadata does not match the info hash %x", magnet.InfoHash)
+	}
+	if magnet.InfoHashV2 != "" && utils.SHA256Hash(info) != magnet.InfoHashV2 {
+		return fmt.Errorf("the metadata does not match the v2 info hash %x", magnet.InfoHashV2)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+const PEER_EVENTS_BUFFER = 64 // Events buffered before the connection stops reading from the peer
+
+// PeerEventType is what happened on a peer connection
+type PeerEventType int
+
+const (
+	PEER_UNCHOKED PeerEventType = iota // Requests can be sent
+	PEER_CHOKED                        // The peer dropped our outstanding requests, they are in Requeued
+	PEER_HAVE                          // The peer has new pieces, from its BITFIELD or a HAVE
+	PEER_BLOCK                         // A block we requested arrived
+)
+
+// PeerEvent is sent by a PeerConn to its download engine
+type PeerEvent struct {
+	Type     PeerEventType
+	Pieces   []int            // PEER_HAVE: the pieces the peer announced
+	Block    *d.Block         // PEER_BLOCK
+	Requeued []d.BlockRequest // PEER_CHOKED: the outstanding requests the peer discarded, no block comes for them anymore
+}
+
+// PeerConn follows the state of a connection to a peer (BEP 3): who chokes whom, who is interested,
+// which pieces the peer has and which of our requests are outstanding
+// Messages are read in the background and turned into events, the Events channel is closed when the connection ends
+type PeerConn struct {
+	wire       *PeerWire
+	pieceCount int
+	Addr       string
+	Extensions *Extensions // Extended messages are dispatched to it, nil when the connection does not use extensions
+	Events     chan PeerEvent
+
+	mu             sync.Mutex
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	bitfield       []byte
+	gotBitfield    bool // A peer sends at most one BITFIELD
+	outstanding    map[d.BlockRequest]bool
+	err            error
+	closed         chan struct{}
+	closeOnce      sync.Once
+}
+
+// PeerState is a snapshot of the four state flags of a connection
+type PeerState struct {
+	AmChoking      bool
+	AmInterested   bool
+	PeerChoking    bool
+	PeerInterested bool
+}
+
+// DialPeer connects and handshakes with a peer of a torrent of pieceCount pieces, then starts reading its messages
+// With peers supporting extensions, the extension handshakes are exchanged to learn how many requests the peer queues
+func DialPeer(infoHash, peerAddr string, pieceCount int) (*PeerConn, error) {
+	conn, handshake, err := Handshake(infoHash, peerAddr, true)
+	if err != nil {
+		return nil, err
+	}
+	c := NewPeerConn(NewPeerWire(conn), pieceCount)
+	c.Addr = peerAddr
+	if handshake.SupportsExtensions() {
+		c.Extensions = NewExtensions()
+		ours, err := d.ExtendedHandshakeMessage(c.Extensions.Handshake(0, conn.RemoteAddr()))
+		if err == nil {
+			err = c.wire.WriteMessage(ours)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error while sending the extension handshake: %v", err)
+		}
+	}
+	c.Start()
+	return c, nil
+}
+
+// NewPeerConn wraps a connection whose handshake is done, Start must be called to read its messages
+// Both sides start choking and not interested, and the peer has no pieces until it says otherwise
+func NewPeerConn(wire *PeerWire, pieceCount int) *PeerConn {
+	return &PeerConn{
+		wire:        wire,
+		pieceCount:  pieceCount,
+		Addr:        wire.Conn().RemoteAddr().String(),
+		Events:      make(chan PeerEvent, PEER_EVENTS_BUFFER),
+		amChoking:   true,
+		peerChoking: true,
+		bitfield:    make([]byte, (pieceCount+7)/8),
+		outstanding: map[d.BlockRequest]bool{},
+		closed:      make(chan struct{}),
+	}
+}
+
+// Start reads the peer's messages in the background, for connections made with NewPeerConn
+// Keep-alives are sent every PEER_KEEPALIVE_INTERVAL, so that the peer keeps the connection open while we are idle or choked
+func (c *PeerConn) Start() {
+	go c.readLoop()
+	go c.keepAliveLoop()
+}
+
+// State returns the current choke and interest flags
+func (c *PeerConn) State() PeerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return PeerState{AmChoking: c.amChoking, AmInterested: c.amInterested, PeerChoking: c.peerChoking, PeerInterested: c.peerInterested}
+}
+
+// HasPiece tells whether the peer announced a piece
+func (c *PeerConn) HasPiece(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return index >= 0 && index < c.pieceCount && c.bitfield[index/8]&(0x80>>(index%8)) != 0
+}
+
+// Outstanding returns the number of requests the peer has not answered yet
+func (c *PeerConn) Outstanding() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.outstanding)
+}
+
+// Reqq returns the number of outstanding requests the peer accepts, from its extension handshake, 0 if unknown
+func (c *PeerConn) Reqq() int {
+	if c.Extensions == nil {
+		return 0
+	}
+	if h := c.Extensions.Peer(); h != nil {
+		return h.Reqq
+	}
+	return 0
+}
+
+// SetInterested sends INTERESTED or NOT_INTERESTED when our interest changes
+func (c *PeerConn) SetInterested(interested bool) error {
+	c.mu.Lock()
+	if c.amInterested == interested {
+		c.mu.Unlock()
+		return nil
+	}
+	c.amInterested = interested
+	c.mu.Unlock()
+	if interested {
+		return c.wire.WriteMessage(d.InterestedMessage())
+	}
+	return c.wire.WriteMessage(d.NotInterestedMessage())
+}
+
+// Request asks the peer for blocks, which fails while the peer chokes us
+func (c *PeerConn) Request(requests ...d.BlockRequest) error {
+	c.mu.Lock()
+	if c.peerChoking {
+		c.mu.Unlock()
+		return fmt.Errorf("the peer is choking us")
+	}
+	messages := make([]*d.PeerMessage, 0, len(requests))
+	for _, r := range requests {
+		c.outstanding[r] = true
+		messages = append(messages, d.RequestMessage(r.Index, r.Begin, r.Length))
+	}
+	c.mu.Unlock()
+	return c.wire.WriteMessage(messages...)
+}
+
+// Cancel withdraws outstanding requests, a block that was already sent is ignored when it arrives
+func (c *PeerConn) Cancel(requests ...d.BlockRequest) error {
+	c.mu.Lock()
+	messages := make([]*d.PeerMessage, 0, len(requests))
+	for _, r := range requests {
+		if c.outstanding[r] {
+			delete(c.outstanding, r)
+			messages = append(messages, d.CancelMessage(r.Index, r.Begin, r.Length))
+		}
+	}
+	c.mu.Unlock()
+	if len(messages) == 0 {
+		return nil
+	}
+	return c.wire.WriteMessage(messages...)
+}
+
+// Err returns why the connection ended once Events is closed, nil when it was closed with Close
+func (c *PeerConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close ends the connection, the Events channel is closed once the reading goroutine returns
+func (c *PeerConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.wire.Close()
+	})
+	return err
+}
+
+// Read messages until the connection fails, updating the state and sending events
+func (c *PeerConn) readLoop() {
+	defer close(c.Events)
+	for {
+		pm, err := c.wire.ReadMessage()
+		if err == nil {
+			err = c.handle(pm)
+		}
+		if err != nil {
+			c.mu.Lock()
+			if !errors.Is(err, net.ErrClosed) {
+				c.err = err
+			}
+			c.mu.Unlock()
+			c.Close()
+			return
+		}
+	}
+}
+
+// Send keep-alives until the connection is closed
+func (c *PeerConn) keepAliveLoop() {
+	ticker := time.NewTicker(PEER_KEEPALIVE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.wire.KeepAlive(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Update the state from a message, and send the matching event
+func (c *PeerConn) handle(pm *d.PeerMessage) error {
+	c.mu.Lock()
+	var event *PeerEvent
+	switch pm.Id {
+	case d.CHOKE:
+		if !c.peerChoking {
+			c.peerChoking = true
+			// Without the fast extension, a choking peer discards every request it did not answer
+			requeued := make([]d.BlockRequest, 0, len(c.outstanding))
+			for r := range c.outstanding {
+				requeued = append(requeued, r)
+			}
+			c.outstanding = map[d.BlockRequest]bool{}
+			event = &PeerEvent{Type: PEER_CHOKED, Requeued: requeued}
+		}
+	case d.UNCHOKE:
+		if c.peerChoking {
+			c.peerChoking = false
+			event = &PeerEvent{Type: PEER_UNCHOKED}
+		}
+	case d.INTERESTED:
+		c.peerInterested = true
+	case d.NOT_INTERESTED:
+		c.peerInterested = false
+	case d.BITFIELD:
+		if c.gotBitfield {
+			c.mu.Unlock()
+			return fmt.Errorf("received a second BITFIELD")
+		}
+		bitfield, err := d.ParseBitfield(pm, c.pieceCount)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		c.gotBitfield = true
+		// It should come first, but some peers send HAVE messages before it
+		var pieces []int
+		for i := range bitfield {
+			c.bitfield[i] |= bitfield[i]
+		}
+		for i := 0; i < c.pieceCount; i++ {
+			if bitfield[i/8]&(0x80>>(i%8)) != 0 {
+				pieces = append(pieces, i)
+			}
+		}
+		event = &PeerEvent{Type: PEER_HAVE, Pieces: pieces}
+	case d.HAVE:
+		index, err := d.ParseHave(pm)
+		if err != nil || int(index) >= c.pieceCount {
+			c.mu.Unlock()
+			return fmt.Errorf("invalid HAVE for piece %d of %d", index, c.pieceCount)
+		}
+		c.bitfield[index/8] |= 0x80 >> (index % 8)
+		event = &PeerEvent{Type: PEER_HAVE, Pieces: []int{int(index)}}
+	case d.PIECE:
+		block, err := d.ParsePiece(pm)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		r := d.BlockRequest{Index: block.Index, Begin: block.Begin, Length: uint32(len(block.Data))}
+		// Blocks we did not ask for, or cancelled, are dropped
+		if c.outstanding[r] {
+			delete(c.outstanding, r)
+			event = &PeerEvent{Type: PEER_BLOCK, Block: block}
+		}
+	case d.EXTENDED:
+		if c.Extensions != nil {
+			c.mu.Unlock()
+			return c.Extensions.Dispatch(pm)
+		}
+	}
+	// We never unchoke the peer, so its REQUEST and CANCEL messages are ignored, as are PORT and unknown messages
+	c.mu.Unlock()
+	if event != nil {
+		// Nobody may be listening anymore once the connection is closed
+		select {
+		case c.Events <- *event:
+		case <-c.closed:
+		}
+	}
+	return nil
+}
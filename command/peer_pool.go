@@ -0,0 +1,68 @@
+package command
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// PeerRetryBackoff is the time before a peer that failed can be handed out again, when a re-announce returns it
+var PeerRetryBackoff = time.Minute
+
+// peerPool hands the addresses of the peers of a download out to the peer workers, each address once at a time
+// Peers returned by tracker re-announces are added while the download runs, failed peers once their backoff expired
+type peerPool struct {
+	mu      sync.Mutex
+	pending []string
+	retryAt map[string]time.Time // Known peers, the zero time while queued or handed out
+	changed chan struct{}        // Closed and replaced whenever peers are added
+}
+
+func newPeerPool(peers []string) *peerPool {
+	p := &peerPool{retryAt: map[string]time.Time{}, changed: make(chan struct{})}
+	p.add(peers)
+	return p
+}
+
+// add queues the new peers and the failed ones whose backoff expired, shuffled so that downloads spread over the swarm
+func (p *peerPool) add(peers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	added := 0
+	for _, peer := range peers {
+		if retryAt, ok := p.retryAt[peer]; !ok || !retryAt.IsZero() && !now.Before(retryAt) {
+			p.retryAt[peer] = time.Time{}
+			p.pending = append(p.pending, peer)
+			added++
+		}
+	}
+	fresh := p.pending[len(p.pending)-added:]
+	rand.Shuffle(len(fresh), func(i, j int) {
+		fresh[i], fresh[j] = fresh[j], fresh[i]
+	})
+	if added > 0 {
+		close(p.changed)
+		p.changed = make(chan struct{})
+	}
+}
+
+// next returns the next peer to download from, false when every peer was handed out
+// The returned channel is closed when more peers are added
+func (p *peerPool) next() (string, bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		return "", false, p.changed
+	}
+	peer := p.pending[0]
+	p.pending = p.pending[1:]
+	return peer, true, p.changed
+}
+
+// failed records that a peer handed out failed, it is only queued again by add after PeerRetryBackoff
+func (p *peerPool) failed(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retryAt[peer] = time.Now().Add(PeerRetryBackoff)
+}
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+const (
+	MAX_PEER_MESSAGE_LENGTH = 1 << 20         // Largest peer message we read
+	PEER_READ_TIMEOUT       = 2 * time.Minute // Peers send a keep-alive at least every 2 minutes
+	PEER_KEEPALIVE_INTERVAL = time.Minute     // We send a keep-alive that often, well within the timeout of the peer
+)
+
+// PeerWire reads and writes length-prefixed peer messages over a connection
+// Messages may be split over several reads or coalesced in one, keep-alives are skipped
+// Reads and writes can happen concurrently, writes from several goroutines are serialized
+type PeerWire struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	Timeout time.Duration // Time the peer has to send each message, PEER_READ_TIMEOUT when zero
+
+	mu sync.Mutex // Serializes writes
+}
+
+func NewPeerWire(conn net.Conn) *PeerWire {
+	return &PeerWire{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Conn returns the underlying connection
+func (w *PeerWire) Conn() net.Conn {
+	return w.conn
+}
+
+// ReadMessage reads the next message, whose payload length is validated for its id
+func (w *PeerWire) ReadMessage() (*d.PeerMessage, error) {
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = PEER_READ_TIMEOUT
+	}
+	for {
+		w.conn.SetReadDeadline(time.Now().Add(timeout))
+		var prefix [4]byte
+		if _, err := io.ReadFull(w.reader, prefix[:]); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint32(prefix[:])
+		if length == 0 {
+			continue // Keep-alive
+		}
+		if length > MAX_PEER_MESSAGE_LENGTH {
+			return nil, fmt.Errorf("peer message of %d bytes exceeds the limit of %d", length, MAX_PEER_MESSAGE_LENGTH)
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(w.reader, message); err != nil {
+			return nil, err
+		}
+		pm := d.NewPeerMessage(message[0], message[1:])
+		if err := pm.Validate(); err != nil {
+			return nil, err
+		}
+		return pm, nil
+	}
+}
+
+// WriteMessage sends messages in a single write
+func (w *PeerWire) WriteMessage(messages ...*d.PeerMessage) error {
+	var buff []byte
+	for _, pm := range messages {
+		buff = append(buff, pm.Encode()...)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.conn.Write(buff)
+	return err
+}
+
+// KeepAlive sends a zero length message so that the peer keeps the connection open
+func (w *PeerWire) KeepAlive() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func (w *PeerWire) Close() error {
+	return w.conn.Close()
+}
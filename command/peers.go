@@ -1,61 +1,170 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
-	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
 	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
+const (
+	TRACKER_PORT                 = 6881
+	TRACKER_UDP_RETRIES          = 3               // Retransmissions to UDP trackers, the BEP 15 maximum of 8 would wait for over an hour
+	TRACKER_UDP_FALLBACK_TIMEOUT = 5 * time.Second // Cap of each UDP attempt while other trackers are left to try
+)
+
+// PeerID identifies this client, it is sent to trackers and in every handshake
+var PeerID = utils.GeneratePeerID()
+
+// AnnounceResponse is the answer of an HTTP or UDP tracker to an announce
+type AnnounceResponse struct {
+	Interval    time.Duration // Time to wait before the next regular announce
+	MinInterval time.Duration // Zero when the tracker does not set one
+	TrackerID   string        // Must be sent back in the next announces, HTTP trackers only
+	Seeders     int
+	Leechers    int
+	Peers       []d.Peer
+	Warning     string // Warning message of the tracker, the announce still succeeded
+}
+
+// TrackerFailure is the failure reason a tracker answered a request with
+type TrackerFailure struct {
+	Tracker string
+	Reason  string
+}
+
+func (e *TrackerFailure) Error() string {
+	return fmt.Sprintf("tracker %s returned an error: %s", e.Tracker, e.Reason)
+}
+
 // Peers gets the list of peers from a torrent file
-// It sends a request to the tracker and gets the list of peers
-// The list of peers is a string of 6 bytes for each peer
-// The first 4 bytes are the IP address and the last 2 bytes are the port number
-// The function returns a list of strings with the IP address and port number of each peer
-func Peers(announceURL, torrentInfoHash string, torrentLength int) ([]string, error) {
+// It sends a request to the tracker and gets the list of peers, IPv4 and IPv6
+// Trackers send them either compact (BEP 23, BEP 7) or as a list of dictionaries that may hold the peer IDs (BEP 3)
+func Peers(announceURL, torrentInfoHash string, torrentLength int) ([]d.Peer, error) {
+	return peersFrom(announceURL, torrentInfoHash, torrentLength, false)
+}
+
+// Get the list of peers from a tracker, giving up sooner on UDP trackers when another tracker can be tried
+func peersFrom(announceURL, torrentInfoHash string, torrentLength int, fallback bool) ([]d.Peer, error) {
+	response, err := announceTo(announceURL, &netclient.AnnounceRequest{
+		InfoHash: torrentInfoHash,
+		PeerID:   PeerID,
+		Port:     TRACKER_PORT,
+		Left:     int64(torrentLength),
+		NumWant:  -1,
+	}, "", fallback)
+	if err != nil {
+		return nil, err
+	}
+	return response.Peers, nil
+}
+
+// Announce sends an announce to an HTTP or UDP tracker
+// trackerID is the tracker id the tracker returned in a previous announce, if any
+func Announce(announceURL string, req *netclient.AnnounceRequest, trackerID string) (*AnnounceResponse, error) {
+	return announceTo(announceURL, req, trackerID, false)
+}
+
+// Send an announce, fallback caps the wait for UDP trackers when other trackers are left to try
+func announceTo(announceURL string, req *netclient.AnnounceRequest, trackerID string, fallback bool) (*AnnounceResponse, error) {
+	if strings.HasPrefix(announceURL, "udp://") {
+		return udpAnnounce(announceURL, req, fallback)
+	}
 	client := &netclient.Client{
 		RemoteURL: announceURL,
 	}
-	queryParameters := fmt.Sprintf("?info_hash=%s&peer_id=%s&port=%d&uploaded=%d&downloaded=%d&left=%d&compact=1", url.QueryEscape(torrentInfoHash), "MyCustomIDValentin?!", 6881, 0, 0, torrentLength)
-	req, err := client.CreateRequest("GET", queryParameters, nil)
+	queryParameters := fmt.Sprintf("info_hash=%s&peer_id=%s&port=%d&uploaded=%d&downloaded=%d&left=%d&compact=1&key=%08x",
+		url.QueryEscape(req.InfoHash), url.QueryEscape(req.PeerID), req.Port, req.Uploaded, req.Downloaded, req.Left, req.Key)
+	if req.Event != netclient.EVENT_NONE {
+		queryParameters += "&event=" + req.Event.String()
+	}
+	if req.NumWant >= 0 {
+		queryParameters += fmt.Sprintf("&numwant=%d", req.NumWant)
+	}
+	if trackerID != "" {
+		queryParameters += "&trackerid=" + url.QueryEscape(trackerID)
+	}
+	// The announce URL may already have a query
+	if strings.Contains(announceURL, "?") {
+		queryParameters = "&" + queryParameters
+	} else {
+		queryParameters = "?" + queryParameters
+	}
+	httpReq, err := client.CreateRequest("GET", queryParameters, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating request: %s", err.Error())
+	}
+	body, err := client.StreamRequest(httpReq)
 	if err != nil {
-		return []string{}, fmt.Errorf("error while creating request: %s", err.Error())
+		return nil, fmt.Errorf("error while making request: %s", err.Error())
 	}
-	resp, err := client.MakeRequest(req)
+	defer body.Close()
+	// Decode the response straight off the connection
+	var response d.TrackerResponse
+	if err := d.NewBencodeDecoder(body).DecodeInto(&response); err != nil {
+		return nil, fmt.Errorf("error while decoding the response: %s", err.Error())
+	}
+	if response.FailureReason != "" {
+		return nil, &TrackerFailure{Tracker: announceURL, Reason: response.FailureReason}
+	}
+	if response.WarningMessage != "" {
+		fmt.Printf("Warning from tracker %s: %s\n", announceURL, response.WarningMessage)
+	}
+	peersList, err := response.PeerList()
 	if err != nil {
-		return []string{}, fmt.Errorf("error while making request: %s", err.Error())
+		return nil, fmt.Errorf("error while parsing the peers: %s", err.Error())
 	}
-	// Decode the response
-	decoded, _, err := decoder.DecodeBencode(string(resp))
+	return &AnnounceResponse{
+		Interval:    time.Duration(response.Interval) * time.Second,
+		MinInterval: time.Duration(response.MinInterval) * time.Second,
+		TrackerID:   response.TrackerID,
+		Seeders:     response.Complete,
+		Leechers:    response.Incomplete,
+		Peers:       peersList,
+		Warning:     response.WarningMessage,
+	}, nil
+}
+
+// Announce to a UDP tracker (BEP 15)
+func udpAnnounce(announceURL string, req *netclient.AnnounceRequest, fallback bool) (*AnnounceResponse, error) {
+	tracker, err := netclient.NewUDPTracker(announceURL)
 	if err != nil {
-		return []string{}, fmt.Errorf("error while decoding the response: %s", err.Error())
-	}
-	fmt.Printf("Decoded: %v\n", decoded)
-	switch decoded.(type) {
-	case map[string]interface{}:
-		peers := decoded.(map[string]interface{})["peers"].(string)
-		// Parse the peers string
-		peersList, err := ParsePeers(peers)
-		if err != nil {
-			return []string{}, fmt.Errorf("error while parsing the peers: %s", err.Error())
-		}
-		return peersList, nil
-	default:
-		return []string{}, fmt.Errorf("error unexpected response: %v", decoded)
-	}
-}
-
-// The peers string is a string of 6 bytes for each peer
-// The first 4 bytes are the IP address and the last 2 bytes are the port number
-func ParsePeers(peers string) ([]string, error) {
-	peersList := []string{}
-	for i := 0; i < len(peers); i += 6 {
-		if i+6 > len(peers) {
-			break
-		}
-		peer := fmt.Sprintf("%d.%d.%d.%d:%d", peers[i], peers[i+1], peers[i+2], peers[i+3], int(peers[i+4])<<8|int(peers[i+5]))
-		peersList = append(peersList, peer)
-	}
-	return peersList, nil
+		return nil, err
+	}
+	tracker.MaxRetries = TRACKER_UDP_RETRIES
+	if fallback {
+		tracker.MaxTimeout = TRACKER_UDP_FALLBACK_TIMEOUT
+	}
+	response, err := tracker.Announce(req)
+	var udpErr *netclient.UDPTrackerError
+	if errors.As(err, &udpErr) {
+		return nil, &TrackerFailure{Tracker: announceURL, Reason: udpErr.Message}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while announcing to %s: %v", announceURL, err)
+	}
+	peersList := make([]d.Peer, 0, len(response.Peers))
+	for _, peer := range response.Peers {
+		peersList = append(peersList, d.Peer{Addr: peer})
+	}
+	return &AnnounceResponse{
+		Interval: time.Duration(response.Interval) * time.Second,
+		Seeders:  response.Seeders,
+		Leechers: response.Leechers,
+		Peers:    peersList,
+	}, nil
+}
+
+// PeerAddrs returns the host:port addresses of peers, to connect to them
+func PeerAddrs(peers []d.Peer) []string {
+	addrs := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		addrs = append(addrs, peer.String())
+	}
+	return addrs
 }
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"slices"
+	"sync"
+)
+
+// pieceQueue is the work queue shared by the peer workers of a download
+// Workers take the pieces their peer has, and put them back when the peer fails to deliver them
+type pieceQueue struct {
+	mu      sync.Mutex
+	pending []int
+	missing map[int]bool  // Pieces not completed yet, pending or in flight
+	changed chan struct{} // Closed and replaced whenever pieces are put back or completed
+}
+
+func newPieceQueue(pieces []int) *pieceQueue {
+	missing := make(map[int]bool, len(pieces))
+	for _, piece := range pieces {
+		missing[piece] = true
+	}
+	return &pieceQueue{pending: slices.Clone(pieces), missing: missing, changed: make(chan struct{})}
+}
+
+// take removes the first pending piece the peer has, false when the peer has none of them
+// The returned channel is closed on the next change of the queue, to wait for more work
+func (q *pieceQueue) take(c *PeerConn) (int, bool, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, piece := range q.pending {
+		if c.HasPiece(piece) {
+			q.pending = slices.Delete(q.pending, i, i+1)
+			return piece, true, q.changed
+		}
+	}
+	return 0, false, q.changed
+}
+
+// putBack makes a piece available to the other workers again
+func (q *pieceQueue) putBack(piece int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, piece)
+	q.notify()
+}
+
+// complete marks a piece as downloaded
+func (q *pieceQueue) complete(piece int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.missing, piece)
+	q.notify()
+}
+
+// done tells whether every piece is downloaded
+func (q *pieceQueue) done() bool {
+	return q.remaining() == 0
+}
+
+// remaining returns the number of pieces not downloaded yet
+func (q *pieceQueue) remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.missing)
+}
+
+// wanted tells whether the peer has a piece not downloaded yet, pending or in flight with another peer
+func (q *pieceQueue) wanted(c *PeerConn) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for piece := range q.missing {
+		if c.HasPiece(piece) {
+			return true
+		}
+	}
+	return false
+}
+
+// changes returns a channel closed on the next change of the queue
+func (q *pieceQueue) changes() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.changed
+}
+
+// Wake the workers waiting for work up, q.mu must be held
+func (q *pieceQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,239 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+const (
+	PIPELINE_INITIAL_DEPTH   = 5                      // Outstanding requests before the throughput of the peer is measured
+	PIPELINE_MIN_DEPTH       = 2                      // Keep the next block requested while one is in transit
+	PIPELINE_MAX_DEPTH       = 500                    // Cap when the peer does not send reqq in its extension handshake
+	PIPELINE_SAMPLE_INTERVAL = 500 * time.Millisecond // Period over which the throughput is measured
+	PIPELINE_QUEUE_TIME      = time.Second            // Data kept queued at the peer on top of the round trip, to absorb jitter
+	PIPELINE_MAX_PIECES      = 8                      // Pieces a worker downloads at once, so that other peers are left pieces to take
+)
+
+// requestPipeline sizes the number of block requests kept outstanding with a peer
+// Until the first throughput sample, each block received adds a request, doubling the depth every round trip;
+// then the depth follows the bandwidth-delay product: the measured throughput times the round trip plus PIPELINE_QUEUE_TIME
+type requestPipeline struct {
+	size        int
+	sentAt      map[d.BlockRequest]time.Time
+	minRTT      time.Duration // Shortest block round trip seen, the latency of the link without queueing at the peer
+	rate        float64       // Smoothed throughput in bytes per second, 0 until measured
+	windowStart time.Time
+	windowBytes int
+}
+
+func newRequestPipeline() *requestPipeline {
+	return &requestPipeline{size: PIPELINE_INITIAL_DEPTH, sentAt: map[d.BlockRequest]time.Time{}}
+}
+
+// depth returns the number of requests to keep outstanding, capped by the reqq of the peer when known
+func (p *requestPipeline) depth(reqq int) int {
+	limit := PIPELINE_MAX_DEPTH
+	if reqq > 0 {
+		limit = min(limit, reqq)
+	}
+	return min(max(p.size, PIPELINE_MIN_DEPTH), limit)
+}
+
+// sent records when requests were sent, to measure their round trip
+func (p *requestPipeline) sent(requests []d.BlockRequest, now time.Time) {
+	// Time spent with an empty pipeline, choked or out of pieces, does not count against the throughput
+	if len(p.sentAt) == 0 {
+		p.windowStart, p.windowBytes = now, 0
+	}
+	for _, r := range requests {
+		p.sentAt[r] = now
+	}
+}
+
+// dropped forgets requests the peer discarded
+func (p *requestPipeline) dropped(requests []d.BlockRequest) {
+	for _, r := range requests {
+		delete(p.sentAt, r)
+	}
+}
+
+// received updates the latency and throughput with a block, and resizes the pipeline after each sample
+func (p *requestPipeline) received(block *d.Block, now time.Time) {
+	r := d.BlockRequest{Index: block.Index, Begin: block.Begin, Length: uint32(len(block.Data))}
+	if sent, ok := p.sentAt[r]; ok {
+		delete(p.sentAt, r)
+		if rtt := now.Sub(sent); p.minRTT == 0 || rtt < p.minRTT {
+			p.minRTT = rtt
+		}
+	}
+	p.windowBytes += len(block.Data)
+	if p.rate == 0 {
+		p.size = min(p.size+1, PIPELINE_MAX_DEPTH)
+	}
+	elapsed := now.Sub(p.windowStart)
+	if elapsed < PIPELINE_SAMPLE_INTERVAL {
+		return
+	}
+	sample := float64(p.windowBytes) / elapsed.Seconds()
+	if p.rate == 0 {
+		p.rate = sample
+	} else {
+		p.rate = (p.rate + sample) / 2
+	}
+	p.windowStart, p.windowBytes = now, 0
+	size := math.Ceil(p.rate * (p.minRTT + PIPELINE_QUEUE_TIME).Seconds() / BLOCK_LENGTH)
+	p.size = int(min(max(size, PIPELINE_MIN_DEPTH), PIPELINE_MAX_DEPTH))
+}
+
+// A piece being downloaded from a peer
+type activePiece struct {
+	index   int
+	data    []byte
+	pending []d.BlockRequest // Blocks not requested yet
+	missing int              // Blocks not received yet
+}
+
+func newActivePiece(index, length int) *activePiece {
+	pending := createRequests(length, index)
+	return &activePiece{index: index, data: make([]byte, length), pending: pending, missing: len(pending)}
+}
+
+// pipelinePieces downloads pieces taken from the queue over a connection, keeping the pipeline of requests full
+// across piece boundaries, until every piece of the queue is downloaded or the peer fails
+// Verified pieces go to store, the pieces still in flight when the peer chokes us or fails are put back in the queue
+func pipelinePieces(c *PeerConn, t *d.TorrentFile, queue *pieceQueue, store func(pieceIndex int, pieceData []byte)) error {
+	pipeline := newRequestPipeline()
+	var active []*activePiece
+	defer func() {
+		for _, piece := range active {
+			queue.putBack(piece.index)
+		}
+	}()
+	// A single deadline pushed back by blocks only, so that a peer sending other messages cannot hold pieces forever
+	deadline := time.NewTimer(PIECE_TIMEOUT)
+	defer deadline.Stop()
+	expired := false
+	resetDeadline := func() {
+		if !deadline.Stop() && !expired {
+			<-deadline.C
+		}
+		deadline.Reset(PIECE_TIMEOUT)
+		expired = false
+	}
+	for {
+		// Taken before the queue is looked at, so that a change cannot be missed
+		changed := queue.changes()
+
+		// Top the pipeline up, taking the next piece only once the blocks of the active ones are all requested
+		// and never holding more than PIPELINE_MAX_PIECES, which bounds the depth with small pieces
+		// Nothing is taken while the peer chokes us, the pieces are left to the other workers
+		if !c.State().PeerChoking {
+			depth := pipeline.depth(c.Reqq())
+			var requests []d.BlockRequest
+			for c.Outstanding()+len(requests) < depth {
+				i := slices.IndexFunc(active, func(piece *activePiece) bool { return len(piece.pending) > 0 })
+				if i < 0 {
+					if len(active) >= PIPELINE_MAX_PIECES {
+						break
+					}
+					index, ok, _ := queue.take(c)
+					if !ok {
+						break
+					}
+					active = append(active, newActivePiece(index, t.PieceSize(index)))
+					continue
+				}
+				requests = append(requests, active[i].pending[0])
+				active[i].pending = active[i].pending[1:]
+			}
+			if len(requests) > 0 {
+				// The wait for blocks starts with the first outstanding request
+				if c.Outstanding() == 0 {
+					resetDeadline()
+				}
+				now := time.Now()
+				if err := c.Request(requests...); err != nil {
+					if !c.State().PeerChoking {
+						return fmt.Errorf("error while sending request messages: %v", err)
+					}
+					// The peer choked us meanwhile, its PEER_CHOKED event puts the pieces back
+				} else {
+					pipeline.sent(requests, now)
+				}
+			}
+		}
+
+		if len(active) == 0 {
+			if queue.done() {
+				return nil
+			}
+			// Wait for an unchoke, for the peer to announce more pieces, or for another worker to put a piece back
+			// An unchoking peer having pieces in flight with other workers is kept, in case they fail
+			choked := c.State().PeerChoking
+			timeout := choked || !queue.wanted(c)
+			if timeout && expired {
+				if choked {
+					return fmt.Errorf("the peer kept us choked for %v", PIECE_TIMEOUT)
+				}
+				return fmt.Errorf("the peer has none of the missing pieces")
+			}
+			select {
+			case <-changed:
+			case _, ok := <-c.Events:
+				if !ok {
+					return fmt.Errorf("connection closed: %v", c.Err())
+				}
+			case <-deadline.C:
+				expired = true
+			}
+			continue
+		}
+
+		select {
+		case event, ok := <-c.Events:
+			if !ok {
+				if err := c.Err(); err != nil {
+					return fmt.Errorf("connection closed: %v", err)
+				}
+				return fmt.Errorf("connection closed")
+			}
+			switch event.Type {
+			case PEER_CHOKED:
+				// The peer discarded our requests, the pieces go back to the queue for the other workers
+				pipeline.dropped(event.Requeued)
+				for _, piece := range active {
+					queue.putBack(piece.index)
+				}
+				active = nil
+			case PEER_BLOCK:
+				resetDeadline()
+				pipeline.received(event.Block, time.Now())
+				// The connection only reports the blocks we requested
+				i := slices.IndexFunc(active, func(piece *activePiece) bool { return piece.index == int(event.Block.Index) })
+				if i < 0 {
+					continue
+				}
+				piece := active[i]
+				copy(piece.data[event.Block.Begin:], event.Block.Data)
+				if piece.missing--; piece.missing > 0 {
+					continue
+				}
+				active = slices.Delete(active, i, i+1)
+				// Check the piece against the SHA1 piece hash, and the merkle trees for v2 torrents
+				if err := t.VerifyPiece(piece.index, piece.data); err != nil {
+					queue.putBack(piece.index)
+					return fmt.Errorf("error while downloading piece %d: %v", piece.index, err)
+				}
+				store(piece.index, piece.data)
+				queue.complete(piece.index)
+				fmt.Printf("successfully downloaded piece %d with peer %s\n", piece.index, c.Addr)
+			}
+		case <-deadline.C:
+			return fmt.Errorf("no block received after %v", PIECE_TIMEOUT)
+		}
+	}
+}
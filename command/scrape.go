@@ -0,0 +1,208 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+)
+
+// ScrapeResult holds the statistics of a torrent's swarm as seen by one of its trackers
+type ScrapeResult struct {
+	Name      string `json:"name"`
+	InfoHash  string `json:"info_hash"` // Hex encoded
+	Tracker   string `json:"tracker"`
+	Seeders   int    `json:"seeders"`
+	Leechers  int    `json:"leechers"`
+	Completed int    `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ScrapeTorrents scrapes every tracker of the given torrent files and magnet links
+// The torrents that share a tracker are scraped with a single request, trackers are queried concurrently
+func ScrapeTorrents(torrentsOrMagnets []string) ([]ScrapeResult, error) {
+	type torrent struct {
+		name, infoHash string
+		trackers       []string
+	}
+	torrents := make([]torrent, 0, len(torrentsOrMagnets))
+	infoHashesByTracker := map[string][]string{}
+	for _, arg := range torrentsOrMagnets {
+		var t torrent
+		if strings.HasPrefix(arg, "magnet:") {
+			magnet, err := MagnetParse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing magnet link: %v", err)
+			}
+			t = torrent{name: magnet.DisplayName, infoHash: magnet.InfoHash, trackers: magnet.Trackers}
+		} else {
+			torrentFile, err := OpenTorrentFile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("error while opening %s: %v", arg, err)
+			}
+			t = torrent{name: torrentFile.Name, infoHash: torrentFile.InfoHash}
+			for _, tier := range torrentFile.Trackers() {
+				for _, tracker := range tier {
+					if !slices.Contains(t.trackers, tracker) {
+						t.trackers = append(t.trackers, tracker)
+					}
+				}
+			}
+		}
+		for _, tracker := range t.trackers {
+			if !slices.Contains(infoHashesByTracker[tracker], t.infoHash) {
+				infoHashesByTracker[tracker] = append(infoHashesByTracker[tracker], t.infoHash)
+			}
+		}
+		torrents = append(torrents, t)
+	}
+
+	type trackerResult struct {
+		stats map[string]netclient.ScrapeStats
+		err   error
+	}
+	results := make(map[string]trackerResult, len(infoHashesByTracker))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for tracker, infoHashes := range infoHashesByTracker {
+		wg.Add(1)
+		go func(tracker string, infoHashes []string) {
+			defer wg.Done()
+			stats, err := Scrape(tracker, infoHashes)
+			mu.Lock()
+			results[tracker] = trackerResult{stats, err}
+			mu.Unlock()
+		}(tracker, infoHashes)
+	}
+	wg.Wait()
+
+	scrapes := []ScrapeResult{}
+	for _, t := range torrents {
+		for _, tracker := range t.trackers {
+			result := ScrapeResult{Name: t.name, InfoHash: fmt.Sprintf("%x", t.infoHash), Tracker: tracker}
+			stats, ok := results[tracker].stats[t.infoHash]
+			switch {
+			case results[tracker].err != nil:
+				result.Error = results[tracker].err.Error()
+			case !ok:
+				result.Error = "the tracker does not know the torrent"
+			default:
+				result.Seeders, result.Leechers, result.Completed = stats.Seeders, stats.Leechers, stats.Completed
+			}
+			scrapes = append(scrapes, result)
+		}
+	}
+	return scrapes, nil
+}
+
+// Scrape asks a tracker for the statistics of the swarms of several torrents, keyed by raw info hash
+func Scrape(announceURL string, infoHashes []string) (map[string]netclient.ScrapeStats, error) {
+	if len(infoHashes) == 0 {
+		return nil, fmt.Errorf("no info hash to scrape")
+	}
+	if strings.HasPrefix(announceURL, "udp://") {
+		return udpScrape(announceURL, infoHashes)
+	}
+	scrapeURL, err := ScrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &netclient.Client{
+		RemoteURL: scrapeURL,
+	}
+	queryParameters := ""
+	for _, infoHash := range infoHashes {
+		queryParameters += "&info_hash=" + url.QueryEscape(infoHash)
+	}
+	// The scrape URL may already have a query
+	if strings.Contains(scrapeURL, "?") {
+		queryParameters = "&" + queryParameters[1:]
+	} else {
+		queryParameters = "?" + queryParameters[1:]
+	}
+	req, err := client.CreateRequest("GET", queryParameters, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating request: %s", err.Error())
+	}
+	body, err := client.StreamRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error while making request: %s", err.Error())
+	}
+	defer body.Close()
+	var response decoder.ScrapeResponse
+	if err := decoder.NewBencodeDecoder(body).DecodeInto(&response); err != nil {
+		return nil, fmt.Errorf("error while decoding the response: %s", err.Error())
+	}
+	if response.FailureReason != "" {
+		return nil, &TrackerFailure{Tracker: announceURL, Reason: response.FailureReason}
+	}
+	stats := make(map[string]netclient.ScrapeStats, len(response.Files))
+	for infoHash, f := range response.Files {
+		stats[infoHash] = netclient.ScrapeStats{Seeders: f.Complete, Completed: f.Downloaded, Leechers: f.Incomplete}
+	}
+	return stats, nil
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker from its announce URL
+// The last path component must start with "announce", which is replaced with "scrape"
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+	dir, last := path.Split(u.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return "", fmt.Errorf("tracker %s does not support scrape", announceURL)
+	}
+	u.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Scrape a UDP tracker, in as many requests as it takes to fit all the info hashes
+func udpScrape(announceURL string, infoHashes []string) (map[string]netclient.ScrapeStats, error) {
+	tracker, err := netclient.NewUDPTracker(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	tracker.MaxRetries = TRACKER_UDP_RETRIES
+	stats := make(map[string]netclient.ScrapeStats, len(infoHashes))
+	for start := 0; start < len(infoHashes); start += netclient.UDP_MAX_SCRAPE_HASHES {
+		batch := infoHashes[start:min(start+netclient.UDP_MAX_SCRAPE_HASHES, len(infoHashes))]
+		batchStats, err := tracker.Scrape(batch)
+		var udpErr *netclient.UDPTrackerError
+		if errors.As(err, &udpErr) {
+			return nil, &TrackerFailure{Tracker: announceURL, Reason: udpErr.Message}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while scraping %s: %v", announceURL, err)
+		}
+		for i, infoHash := range batch {
+			stats[infoHash] = batchStats[i]
+		}
+	}
+	return stats, nil
+}
+
+// FormatScrapeResults lays out scrape results as a table
+func FormatScrapeResults(results []ScrapeResult) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tINFO HASH\tTRACKER\tSEEDERS\tLEECHERS\tCOMPLETED\tERROR")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t%s\n", r.Name, r.InfoHash, r.Tracker, r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t\n", r.Name, r.InfoHash, r.Tracker, r.Seeders, r.Leechers, r.Completed)
+	}
+	w.Flush()
+	return b.String()
+}
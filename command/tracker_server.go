@@ -0,0 +1,334 @@
+package command
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+const (
+	TRACKER_SERVER_ADDR           = ":6969"
+	TRACKER_SERVER_INTERVAL       = 30 * time.Minute
+	TRACKER_SERVER_MIN_INTERVAL   = time.Minute
+	TRACKER_DEFAULT_NUMWANT       = 50
+	TRACKER_MAX_NUMWANT           = 200
+	TRACKER_SERVER_HEADER_TIMEOUT = 5 * time.Second  // Time a client has to send the request headers
+	TRACKER_SERVER_READ_TIMEOUT   = 10 * time.Second // Time a client has to send the whole request
+	TRACKER_SERVER_WRITE_TIMEOUT  = 10 * time.Second // Time a client has to read the response
+)
+
+// TrackerServer is an HTTP tracker keeping its swarms in memory
+// It answers announces in the compact (BEP 23, BEP 7) and dictionary (BEP 3) models, and scrapes
+type TrackerServer struct {
+	Interval time.Duration   // Interval sent to clients, TRACKER_SERVER_INTERVAL when zero
+	PeerTTL  time.Duration   // Peers that did not announce for that long are dropped, twice the interval when zero
+	Allowed  map[string]bool // Raw info hashes the tracker accepts, any when nil
+	TrustIP  bool            // Honor the ip parameter of any client, else only of loopback and private addresses
+
+	mu     sync.Mutex
+	swarms map[string]*swarm
+}
+
+// The peers of a torrent, keyed by peer ID
+type swarm struct {
+	peers     map[string]*swarmPeer
+	completed int
+}
+
+type swarmPeer struct {
+	id   string
+	addr netip.AddrPort
+	left int64
+	seen time.Time
+}
+
+func NewTrackerServer() *TrackerServer {
+	return &TrackerServer{swarms: map[string]*swarm{}}
+}
+
+// ServeTracker runs a tracker on addr, it only returns on error
+// Announces are served on /announce and scrapes on /scrape
+func ServeTracker(addr string, server *TrackerServer) error {
+	go func() {
+		for range time.Tick(server.interval()) {
+			server.Expire()
+		}
+	}()
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           server,
+		ReadHeaderTimeout: TRACKER_SERVER_HEADER_TIMEOUT,
+		ReadTimeout:       TRACKER_SERVER_READ_TIMEOUT,
+		WriteTimeout:      TRACKER_SERVER_WRITE_TIMEOUT,
+	}
+	return httpServer.ListenAndServe()
+}
+
+func (s *TrackerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var response any
+	switch r.URL.Path {
+	case "/announce":
+		response = s.announce(r)
+	case "/scrape":
+		response = s.scrape(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	encoded, err := encoder.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(encoded)
+}
+
+// Expire drops the peers that stopped announcing, and the swarms left empty
+func (s *TrackerServer) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for infoHash, sw := range s.swarms {
+		s.expire(sw)
+		if len(sw.peers) == 0 && sw.completed == 0 {
+			delete(s.swarms, infoHash)
+		}
+	}
+}
+
+// A peer of the dictionary model of announce responses
+type trackerDictPeer struct {
+	ID   string `bencode:"peer id,omitempty"`
+	IP   string `bencode:"ip"`
+	Port int    `bencode:"port"`
+}
+
+// Register the announcing peer in its swarm and answer with the other peers
+func (s *TrackerServer) announce(r *http.Request) *d.TrackerResponse {
+	query := r.URL.Query()
+	infoHash, peerID := query.Get("info_hash"), query.Get("peer_id")
+	if len(infoHash) != 20 {
+		return &d.TrackerResponse{FailureReason: "invalid info_hash"}
+	}
+	if len(peerID) != 20 {
+		return &d.TrackerResponse{FailureReason: "invalid peer_id"}
+	}
+	if s.Allowed != nil && !s.Allowed[infoHash] {
+		return &d.TrackerResponse{FailureReason: "unknown torrent"}
+	}
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return &d.TrackerResponse{FailureReason: "invalid port"}
+	}
+	left, err := strconv.ParseInt(query.Get("left"), 10, 64)
+	if err != nil || left < 0 {
+		return &d.TrackerResponse{FailureReason: "invalid left"}
+	}
+	addr, err := announceAddr(r, s.TrustIP)
+	if err != nil {
+		return &d.TrackerResponse{FailureReason: err.Error()}
+	}
+	numWant := TRACKER_DEFAULT_NUMWANT
+	if n, err := strconv.Atoi(query.Get("numwant")); err == nil && n >= 0 {
+		numWant = min(n, TRACKER_MAX_NUMWANT)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw := s.swarms[infoHash]
+	if sw == nil {
+		sw = &swarm{peers: map[string]*swarmPeer{}}
+		s.swarms[infoHash] = sw
+	}
+	s.expire(sw)
+	switch query.Get("event") {
+	case "stopped":
+		delete(sw.peers, peerID)
+	case "completed":
+		// Only a peer we saw leeching counts as a download, so that repeated completed events are ignored
+		if p, ok := sw.peers[peerID]; ok && p.left > 0 {
+			sw.completed++
+		}
+		fallthrough
+	default:
+		sw.peers[peerID] = &swarmPeer{id: peerID, addr: netip.AddrPortFrom(addr, uint16(port)), left: left, seen: time.Now()}
+	}
+
+	var peers []*swarmPeer
+	for id, p := range sw.peers {
+		if len(peers) == numWant {
+			break
+		}
+		if id != peerID {
+			peers = append(peers, p)
+		}
+	}
+	seeders, leechers := sw.counts()
+	response := &d.TrackerResponse{
+		Interval:    int(s.interval().Seconds()),
+		MinInterval: int(min(TRACKER_SERVER_MIN_INTERVAL, s.interval()).Seconds()),
+		Complete:    seeders,
+		Incomplete:  leechers,
+	}
+	if query.Get("compact") == "1" {
+		var peers4, peers6 []byte
+		for _, p := range peers {
+			if p.addr.Addr().Is4() {
+				peers4 = binary.BigEndian.AppendUint16(append(peers4, p.addr.Addr().AsSlice()...), p.addr.Port())
+			} else {
+				peers6 = binary.BigEndian.AppendUint16(append(peers6, p.addr.Addr().AsSlice()...), p.addr.Port())
+			}
+		}
+		response.Peers, _ = encoder.Marshal(string(peers4))
+		response.Peers6 = string(peers6)
+		return response
+	}
+	dictPeers := make([]trackerDictPeer, 0, len(peers))
+	for _, p := range peers {
+		dictPeer := trackerDictPeer{IP: p.addr.Addr().String(), Port: int(p.addr.Port())}
+		if query.Get("no_peer_id") != "1" {
+			dictPeer.ID = p.id
+		}
+		dictPeers = append(dictPeers, dictPeer)
+	}
+	response.Peers, _ = encoder.Marshal(dictPeers)
+	return response
+}
+
+// Answer with the stats of the requested swarms, or of every swarm when no info hash is given
+func (s *TrackerServer) scrape(r *http.Request) *d.ScrapeResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infoHashes := r.URL.Query()["info_hash"]
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, infoHash)
+		}
+	}
+	files := map[string]d.ScrapeFile{}
+	for _, infoHash := range infoHashes {
+		if s.Allowed != nil && !s.Allowed[infoHash] {
+			continue
+		}
+		file := d.ScrapeFile{}
+		if sw, ok := s.swarms[infoHash]; ok {
+			s.expire(sw)
+			file.Complete, file.Incomplete = sw.counts()
+			file.Downloaded = sw.completed
+		}
+		files[infoHash] = file
+	}
+	return &d.ScrapeResponse{Files: files}
+}
+
+// The address of the announcing peer is the one it connected from, unless it gives an ip parameter
+// The ip parameter is only honored from loopback and private addresses, such as a NAT gateway, or when trustIP is set,
+// so that a client cannot add the address of a third party to the swarm
+func announceAddr(r *http.Request, trustIP bool) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	remote = remote.Unmap()
+	ip := r.URL.Query().Get("ip")
+	if ip == "" || !trustIP && !remote.IsLoopback() && !remote.IsPrivate() {
+		return remote, nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid ip")
+	}
+	return addr.Unmap(), nil
+}
+
+// Drop the peers of a swarm that did not announce within the peer TTL, s.mu must be held
+func (s *TrackerServer) expire(sw *swarm) {
+	ttl := s.PeerTTL
+	if ttl == 0 {
+		ttl = 2 * s.interval()
+	}
+	for id, p := range sw.peers {
+		if time.Since(p.seen) > ttl {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+func (s *TrackerServer) interval() time.Duration {
+	if s.Interval == 0 {
+		return TRACKER_SERVER_INTERVAL
+	}
+	return s.Interval
+}
+
+// Seeders are the peers with nothing left to download
+func (sw *swarm) counts() (seeders, leechers int) {
+	for _, p := range sw.peers {
+		if p.left == 0 {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return seeders, leechers
+}
+
+// Parse the arguments of the tracker command into the listen address and the tracker
+// Each positional argument is a torrent file or a hex info hash the tracker accepts, it accepts any without them
+func parseTrackerArgs(args []string) (addr string, server *TrackerServer, err error) {
+	server = NewTrackerServer()
+	flags := flag.NewFlagSet("tracker", flag.ContinueOnError)
+	flags.StringVar(&addr, "addr", TRACKER_SERVER_ADDR, "address to listen on")
+	flags.DurationVar(&server.Interval, "interval", TRACKER_SERVER_INTERVAL, "announce interval sent to clients")
+	flags.BoolVar(&server.TrustIP, "trust-ip", false, "honor the ip parameter of announces from public addresses")
+	if err := flags.Parse(args); err != nil {
+		return "", nil, err
+	}
+	if flags.NArg() == 0 {
+		return addr, server, nil
+	}
+	server.Allowed = map[string]bool{}
+	for _, arg := range flags.Args() {
+		if infoHash, err := hex.DecodeString(arg); err == nil && len(infoHash) == 20 {
+			server.Allowed[string(infoHash)] = true
+			continue
+		}
+		torrent, err := OpenTorrentFile(arg)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s is neither an info hash nor a torrent file: %v", arg, err)
+		}
+		server.Allowed[torrent.InfoHash] = true
+		// Hybrid torrents are announced with both info hashes
+		if torrent.InfoHashV2 != "" {
+			server.Allowed[torrent.InfoHashV2[:20]] = true
+		}
+	}
+	return addr, server, nil
+}
+
+// Format the accepted info hashes for the startup message
+func formatAllowed(allowed map[string]bool) string {
+	if allowed == nil {
+		return "any torrent"
+	}
+	hashes := make([]string, 0, len(allowed))
+	for infoHash := range allowed {
+		hashes = append(hashes, hex.EncodeToString([]byte(infoHash)))
+	}
+	return strings.Join(hashes, ", ")
+}
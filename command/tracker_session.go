@@ -0,0 +1,306 @@
+package command
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+)
+
+const (
+	TRACKER_DEFAULT_INTERVAL = 30 * time.Minute // Used when the tracker does not send an interval
+	TRACKER_RETRY_INTERVAL   = time.Minute      // Wait after a failed announce before trying again
+	TRACKER_FINAL_TIMEOUT    = 10 * time.Second // Time Completed and Stop wait for the tracker, so that a dead one cannot hold the exit up
+)
+
+// TrackerSession keeps a torrent announced to its trackers for as long as it is downloaded or seeded
+// It sends the started event, re-announces every interval with the transfer stats, and sends completed and stopped
+// Announces go to the trackers in BEP 12 order, completed and stopped go to the tracker that answered last
+type TrackerSession struct {
+	FinalTimeout time.Duration // Time Completed and Stop wait for the tracker, TRACKER_FINAL_TIMEOUT when zero
+
+	tiers    *TrackerTiers
+	infoHash string
+	key      uint32 // Lets trackers recognize us when our IP address changes (BEP 7)
+
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	left       atomic.Int64
+
+	mu               sync.Mutex // Not held during announces, so that Completed and Stop never wait for a slow tracker
+	tracker          string     // Tracker that answered last
+	trackerIDs       map[string]string
+	completedPending bool      // The completed event could not be sent, the next announce of the session's goroutine sends it
+	wanted           bool      // Peers were asked for, announce as soon as the min interval allows it
+	next             time.Time // Next regular announce
+	earliest         time.Time // The min interval forbids announcing before
+	retryAt          time.Time // Set after a failed announce
+	onPeers          func(peers []d.Peer)
+
+	wake    chan struct{}
+	stop    chan struct{}
+	running bool
+}
+
+func NewTrackerSession(tiers *TrackerTiers, infoHash string, left int) *TrackerSession {
+	var key [4]byte
+	rand.Read(key[:])
+	s := &TrackerSession{
+		tiers:      tiers,
+		infoHash:   infoHash,
+		key:        binary.BigEndian.Uint32(key[:]),
+		trackerIDs: map[string]string{},
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	s.left.Store(int64(left))
+	return s
+}
+
+// AddUploaded and AddDownloaded count the bytes of torrent data sent to and received from peers
+func (s *TrackerSession) AddUploaded(n int) {
+	s.uploaded.Add(int64(n))
+}
+
+func (s *TrackerSession) AddDownloaded(n int) {
+	s.downloaded.Add(int64(n))
+}
+
+// SetLeft sets the number of bytes still missing to have the whole torrent
+func (s *TrackerSession) SetLeft(n int) {
+	s.left.Store(int64(n))
+}
+
+// Start sends the started event and returns the peers of the first tracker that answers
+// Re-announces are then sent in the background until Stop
+func (s *TrackerSession) Start() ([]d.Peer, error) {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+		s.mu.Unlock()
+		return nil, fmt.Errorf("the tracker session is stopped")
+	default:
+	}
+	if s.running {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("the tracker session is already started")
+	}
+	s.running = true
+	s.mu.Unlock()
+	var peers []d.Peer
+	_, err := s.tiers.Announce(func(tracker string, fallback bool) error {
+		response, err := s.announce(tracker, netclient.EVENT_STARTED, fallback)
+		if err == nil {
+			peers = response.Peers
+		}
+		return err
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+		return nil, err
+	}
+	go s.run()
+	return peers, nil
+}
+
+// SetOnPeers sets the function called with the peers of every re-announce, from the session's goroutine
+func (s *TrackerSession) SetOnPeers(onPeers func(peers []d.Peer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPeers = onPeers
+}
+
+// Reannounce asks for more peers, the announce is sent as soon as the tracker's min interval allows it
+func (s *TrackerSession) Reannounce() {
+	s.mu.Lock()
+	s.wanted = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Completed tells the tracker the download is complete, it must be called once
+// When the tracker cannot be reached the event is sent with the next announce
+func (s *TrackerSession) Completed() error {
+	s.mu.Lock()
+	s.left.Store(0)
+	tracker := s.tracker
+	s.mu.Unlock()
+	err := fmt.Errorf("no tracker answered yet")
+	if tracker != "" {
+		err = s.finalAnnounce(tracker, netclient.EVENT_COMPLETED)
+	}
+	if err != nil {
+		// Only set once this announce is over, so that the session's goroutine never sends the event at the same time
+		s.mu.Lock()
+		s.completedPending = true
+		s.failed()
+		s.mu.Unlock()
+		s.signal()
+	}
+	return err
+}
+
+// Stop ends the re-announces and sends the stopped event to the tracker that answered last
+// A re-announce still in flight is not waited for, its peers are dropped
+func (s *TrackerSession) Stop() error {
+	s.mu.Lock()
+	running := s.running
+	s.running = false
+	tracker := s.tracker
+	s.mu.Unlock()
+	if !running {
+		return nil
+	}
+	close(s.stop)
+	if tracker == "" {
+		return fmt.Errorf("no tracker answered yet")
+	}
+	return s.finalAnnounce(tracker, netclient.EVENT_STOPPED)
+}
+
+// Send re-announces when they are due, until the session is stopped
+func (s *TrackerSession) run() {
+	for {
+		s.mu.Lock()
+		var due time.Time
+		switch {
+		case s.completedPending:
+			due = s.retryAt
+		case s.wanted:
+			due = s.earliest
+			if s.retryAt.After(due) {
+				due = s.retryAt
+			}
+		default:
+			due = s.next
+		}
+		s.mu.Unlock()
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		event := netclient.EVENT_NONE
+		if s.completedPending {
+			event = netclient.EVENT_COMPLETED
+		}
+		s.mu.Unlock()
+		var peers []d.Peer
+		_, err := s.tiers.Announce(func(tracker string, fallback bool) error {
+			// Once stopped, the remaining trackers are not tried
+			select {
+			case <-s.stop:
+				return fmt.Errorf("the tracker session is stopped")
+			default:
+			}
+			response, err := s.announce(tracker, event, fallback)
+			if err == nil {
+				peers = response.Peers
+			}
+			return err
+		})
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		s.mu.Lock()
+		if err != nil {
+			s.failed()
+		}
+		onPeers := s.onPeers
+		s.mu.Unlock()
+		if err != nil {
+			fmt.Printf("error while announcing: %v\n", err)
+		} else if onPeers != nil {
+			onPeers(peers)
+		}
+	}
+}
+
+// Send the completed or stopped event, giving up after FinalTimeout
+// An announce that times out goes on in the background, its response still updates the session
+func (s *TrackerSession) finalAnnounce(tracker string, event netclient.AnnounceEvent) error {
+	timeout := s.FinalTimeout
+	if timeout == 0 {
+		timeout = TRACKER_FINAL_TIMEOUT
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.announce(tracker, event, true)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("tracker %s did not answer the %s event within %v", tracker, event, timeout)
+	}
+}
+
+// Send an announce with the current stats and update the schedule from the response, s.mu must not be held
+func (s *TrackerSession) announce(tracker string, event netclient.AnnounceEvent, fallback bool) (*AnnounceResponse, error) {
+	s.mu.Lock()
+	trackerID := s.trackerIDs[tracker]
+	s.mu.Unlock()
+	response, err := announceTo(tracker, &netclient.AnnounceRequest{
+		InfoHash:   s.infoHash,
+		PeerID:     PeerID,
+		Port:       TRACKER_PORT,
+		Uploaded:   s.uploaded.Load(),
+		Downloaded: s.downloaded.Load(),
+		Left:       s.left.Load(),
+		Event:      event,
+		Key:        s.key,
+		NumWant:    -1,
+	}, trackerID, fallback)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	interval := response.Interval
+	if interval <= 0 {
+		interval = TRACKER_DEFAULT_INTERVAL
+	}
+	s.tracker = tracker
+	if response.TrackerID != "" {
+		s.trackerIDs[tracker] = response.TrackerID
+	}
+	s.next = now.Add(interval)
+	s.earliest = now.Add(response.MinInterval)
+	s.retryAt = time.Time{}
+	s.wanted = false
+	if event == netclient.EVENT_COMPLETED {
+		s.completedPending = false
+	}
+	return response, nil
+}
+
+// Retry after TRACKER_RETRY_INTERVAL, s.mu must be held
+func (s *TrackerSession) failed() {
+	s.retryAt = time.Now().Add(TRACKER_RETRY_INTERVAL)
+	s.next = s.retryAt
+}
+
+// Wake the session's goroutine up to reschedule
+func (s *TrackerSession) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
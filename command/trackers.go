@@ -0,0 +1,101 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+// TrackerTiers picks the tracker to announce to following the BEP 12 rules:
+// trackers are shuffled within each tier once, tiers are tried in order and trackers in order within a tier,
+// and a tracker that responds is moved to the front of its tier so it is tried first next time
+type TrackerTiers struct {
+	mu    sync.Mutex
+	tiers [][]string
+}
+
+func NewTrackerTiers(tiers [][]string) *TrackerTiers {
+	shuffled := make([][]string, 0, len(tiers))
+	seen := make(map[string]bool)
+	for _, tier := range tiers {
+		urls := make([]string, 0, len(tier))
+		for _, u := range tier {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+		rand.Shuffle(len(urls), func(i, j int) {
+			urls[i], urls[j] = urls[j], urls[i]
+		})
+		if len(urls) > 0 {
+			shuffled = append(shuffled, urls)
+		}
+	}
+	return &TrackerTiers{tiers: shuffled}
+}
+
+// Tiers returns a copy of the tiers in their current order
+func (t *TrackerTiers) Tiers() [][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tiers := make([][]string, len(t.tiers))
+	for i, tier := range t.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Announce calls try with each tracker in turn until one succeeds, and returns the tracker that succeeded
+// fallback tells try whether other trackers are left to try after this one
+// The error lists every tracker that failed when none of them succeeds
+func (t *TrackerTiers) Announce(try func(tracker string, fallback bool) error) (string, error) {
+	var errs []error
+	tiers := t.Tiers()
+	for i, tier := range tiers {
+		for j, tracker := range tier {
+			fallback := j < len(tier)-1 || i < len(tiers)-1
+			if err := try(tracker, fallback); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", tracker, err))
+				continue
+			}
+			t.promote(i, tracker)
+			return tracker, nil
+		}
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("no tracker to announce to")
+	}
+	return "", fmt.Errorf("every tracker failed: %w", errors.Join(errs...))
+}
+
+// Move a tracker that responded to the front of its tier
+func (t *TrackerTiers) promote(tierIndex int, tracker string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tier := t.tiers[tierIndex]
+	for i, u := range tier {
+		if u == tracker {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = tracker
+			return
+		}
+	}
+}
+
+// PeersFromTiers gets the list of peers from the first tracker that responds with peers
+func PeersFromTiers(tiers *TrackerTiers, torrentInfoHash string, torrentLength int) ([]d.Peer, error) {
+	var peers []d.Peer
+	_, err := tiers.Announce(func(tracker string, fallback bool) error {
+		var err error
+		peers, err = peersFrom(tracker, torrentInfoHash, torrentLength, fallback)
+		if err == nil && len(peers) == 0 {
+			return fmt.Errorf("no peers")
+		}
+		return err
+	})
+	return peers, err
+}
@@ -0,0 +1,136 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	d "github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+type PieceStatus string
+
+const (
+	PIECE_COMPLETE PieceStatus = "complete" // The piece data matches its hash
+	PIECE_MISSING  PieceStatus = "missing"  // Part of the piece's data is not on disk
+	PIECE_CORRUPT  PieceStatus = "corrupt"  // The piece data is on disk but does not match its hash
+)
+
+// PieceReport is the result of the verification of a single piece
+type PieceReport struct {
+	Index  int         `json:"index"`
+	Length int         `json:"length"`
+	Status PieceStatus `json:"status"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// VerifyReport summarises the verification of the data of a torrent
+type VerifyReport struct {
+	Complete int           `json:"complete"`
+	Missing  int           `json:"missing"`
+	Corrupt  int           `json:"corrupt"`
+	Pieces   []PieceReport `json:"pieces"`
+}
+
+func (r *VerifyReport) String() string {
+	return fmt.Sprintf("Pieces: %d\nComplete: %d\nMissing: %d\nCorrupt: %d\n", len(r.Pieces), r.Complete, r.Missing, r.Corrupt)
+}
+
+// Verify hashes every piece of the torrent's data found at path and reports which pieces are complete, missing or corrupt
+// path is laid out the way Download writes it: the file itself for single-file torrents, the directory holding <name> otherwise
+func Verify(t *d.TorrentFile, path string) (*VerifyReport, error) {
+	data, err := openTorrentData(t, path)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	report := &VerifyReport{Pieces: make([]PieceReport, 0, t.PieceCount())}
+	offset := 0
+	for i := 0; i < t.PieceCount(); i++ {
+		piece := PieceReport{Index: i, Length: t.PieceSize(i), Status: PIECE_COMPLETE}
+		buff := make([]byte, piece.Length)
+		if err := data.ReadAt(buff, offset); err != nil {
+			piece.Status, piece.Error = PIECE_MISSING, err.Error()
+			report.Missing++
+		} else if err := t.VerifyPiece(i, buff); err != nil {
+			piece.Status, piece.Error = PIECE_CORRUPT, err.Error()
+			report.Corrupt++
+		} else {
+			report.Complete++
+		}
+		report.Pieces = append(report.Pieces, piece)
+		offset += piece.Length
+	}
+	return report, nil
+}
+
+// The files of a torrent on disk, read as the concatenation of all their data
+// Missing files are reported when their data is read so a partial download can still be verified
+type torrentData struct {
+	files   []d.FileEntry
+	handles []*os.File // nil for padding files and files that could not be opened
+	errs    []error
+}
+
+func openTorrentData(t *d.TorrentFile, path string) (*torrentData, error) {
+	root := path
+	if t.MultiFile {
+		var err error
+		if root, err = utils.SafeJoin(path, t.Name); err != nil {
+			return nil, err
+		}
+	}
+	data := &torrentData{files: t.Files, handles: make([]*os.File, len(t.Files)), errs: make([]error, len(t.Files))}
+	for i, f := range t.Files {
+		if f.Padding || f.Length == 0 {
+			continue
+		}
+		filePath := path
+		if t.MultiFile {
+			var err error
+			if filePath, err = utils.SafeJoin(root, f.Path...); err != nil {
+				data.Close()
+				return nil, err
+			}
+		}
+		data.handles[i], data.errs[i] = os.Open(filePath)
+		if errors.Is(data.errs[i], fs.ErrNotExist) {
+			data.errs[i] = fmt.Errorf("file %s does not exist", filePath)
+		}
+	}
+	return data, nil
+}
+
+// Fill buff with the data starting at offset, padding files read as zeroes
+func (data *torrentData) ReadAt(buff []byte, offset int) error {
+	end := offset + len(buff)
+	for i, f := range data.files {
+		start, stop := max(offset, f.Offset), min(end, f.Offset+f.Length)
+		if start >= stop || f.Padding {
+			continue
+		}
+		if data.errs[i] != nil {
+			return data.errs[i]
+		}
+		n, err := data.handles[i].ReadAt(buff[start-offset:stop-offset], int64(start-f.Offset))
+		if err == io.EOF {
+			return fmt.Errorf("file %s is truncated at %d bytes", data.handles[i].Name(), start-f.Offset+n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (data *torrentData) Close() {
+	for _, h := range data.handles {
+		if h != nil {
+			h.Close()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ValueSpan returns the offsets [start, end) of a value nested in bencoded dictionaries, following the given keys
+// The span covers the original bytes, so hashing data[start:end] gives the same result as any other client
+// even when the input is not canonical, e.g. ValueSpan(torrent, "info") locates the info dictionary
+func ValueSpan(data []byte, keys ...string) (start, end int, err error) {
+	d := NewBencodeDecoder(bytes.NewReader(data))
+	for depth, key := range keys {
+		found, err := d.seekKey(key)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("key %q not found at depth %d", key, depth)
+		}
+	}
+	start = int(d.Offset())
+	if _, err := d.decodeValue(); err != nil {
+		return 0, 0, err
+	}
+	return start, int(d.Offset()), nil
+}
+
+// Move the decoder to the value of key in the dictionary that starts at the current offset
+func (d *BencodeDecoder) seekKey(key string) (bool, error) {
+	b, err := d.peekByte()
+	if err != nil {
+		return false, err
+	}
+	if b != 'd' {
+		return false, &SyntaxError{Offset: d.offset, Err: fmt.Errorf("expected a dictionary, got %q", b)}
+	}
+	if err := d.enter(); err != nil {
+		return false, err
+	}
+	previous := ""
+	for i := 0; ; i++ {
+		b, err := d.peekByte()
+		if err != nil {
+			return false, err
+		}
+		if b == 'e' {
+			return false, nil
+		}
+		k, err := d.decodeKey(previous, i == 0)
+		if err != nil {
+			return false, err
+		}
+		if k == key {
+			return true, nil
+		}
+		previous = k
+		if _, err := d.decodeValue(); err != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+const (
+	MAX_STRING_LENGTH = 64 << 20 // Largest byte string we accept, guards against absurd length prefixes
+	MAX_NESTING_DEPTH = 512      // Deepest list/dictionary nesting we accept
+	MAX_NUMBER_DIGITS = 256      // Longest integer or length prefix we accept, sign included
+)
+
+var errUnsupportedType = errors.New(ErrUnsupportedBencodeType)
+
+// Non-canonical encodings, only rejected in strict mode
+var (
+	ErrLeadingZero  = errors.New("non-canonical leading zero")
+	ErrNegativeZero = errors.New("non-canonical negative zero")
+	ErrUnsortedKeys = errors.New("dictionary keys are not sorted")
+	ErrDuplicateKey = errors.New("duplicate dictionary key")
+)
+
+// SyntaxError describes a malformed bencode token and the offset of the byte where it was found
+type SyntaxError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// BencodeDecoder reads bencoded values from a stream
+// The reader is buffered internally, so a BencodeDecoder may read past the end of the value being decoded
+// Use the same decoder to read consecutive values from one stream
+type BencodeDecoder struct {
+	r      *bufio.Reader
+	offset int64 // Number of bytes consumed so far
+	depth  int   // Current list/dictionary nesting depth
+	strict bool  // Reject anything that is not the canonical encoding of its value
+
+	useByteStrings bool // Decode byte strings as ByteString instead of string
+	useInt64       bool // Decode integers as int64, or *big.Int when they do not fit, instead of int
+
+	capture *bytes.Buffer // When set, receives a copy of every byte consumed
+}
+
+func NewBencodeDecoder(r io.Reader) *BencodeDecoder {
+	return &BencodeDecoder{
+		r: bufio.NewReader(r),
+	}
+}
+
+// SetStrict makes the decoder reject every non-canonical encoding:
+// leading zeros, negative zero, and unsorted or duplicate dictionary keys
+// Use it for data coming from untrusted peers and trackers
+func (d *BencodeDecoder) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// UseByteStrings makes Decode return byte strings as ByteString values, dictionary keys stay strings
+// It tells binary data such as piece hashes apart from text when the values are rendered
+func (d *BencodeDecoder) UseByteStrings() {
+	d.useByteStrings = true
+}
+
+// UseInt64 makes Decode return integers as int64, and as *big.Int when they do not fit in 64 bits
+func (d *BencodeDecoder) UseInt64() {
+	d.useInt64 = true
+}
+
+// Offset returns the number of bytes consumed by the decoder so far
+func (d *BencodeDecoder) Offset() int64 {
+	return d.offset
+}
+
+// Decode reads the next bencoded value from the stream
+// It returns io.EOF if the stream ends cleanly before a new value starts
+func (d *BencodeDecoder) Decode() (interface{}, error) {
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	return d.decodeValue()
+}
+
+func (d *BencodeDecoder) decodeValue() (interface{}, error) {
+	b, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b == 'i' && d.useInt64:
+		return d.decodeInteger64()
+	case b == 'i':
+		return d.decodeInteger()
+	case b == 'l':
+		return d.decodeList()
+	case b == 'd':
+		return d.decodeDictionary()
+	case b >= '0' && b <= '9' && d.useByteStrings:
+		s, err := d.decodeString()
+		if err != nil {
+			return nil, err
+		}
+		return ByteString(s), nil
+	case b >= '0' && b <= '9':
+		return d.decodeString()
+	default:
+		return nil, &SyntaxError{Offset: d.offset, Err: errUnsupportedType}
+	}
+}
+
+// Decode a byte string of the form <length>:<contents>
+func (d *BencodeDecoder) decodeString() (string, error) {
+	start := d.offset
+	digits, err := d.readUntil(':')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseInt(string(digits), 10, 64)
+	if (err != nil && !errors.Is(err, strconv.ErrRange)) || digits[0] == '-' {
+		return "", &SyntaxError{Offset: start, Err: fmt.Errorf("invalid string length %q", digits)}
+	}
+	if err != nil || length > MAX_STRING_LENGTH {
+		return "", &SyntaxError{Offset: start, Err: fmt.Errorf("string length %s exceeds the limit of %d bytes", digits, MAX_STRING_LENGTH)}
+	}
+	if d.strict && len(digits) > 1 && digits[0] == '0' {
+		return "", &SyntaxError{Offset: start, Err: fmt.Errorf("%w in string length %q", ErrLeadingZero, digits)}
+	}
+	// Grow the buffer as data arrives instead of trusting the length prefix upfront
+	var buff bytes.Buffer
+	var dst io.Writer = &buff
+	if d.capture != nil {
+		dst = io.MultiWriter(&buff, d.capture)
+	}
+	n, err := io.CopyN(dst, d.r, length)
+	d.offset += n
+	if err != nil {
+		return "", &SyntaxError{Offset: start, Err: fmt.Errorf("string of length %d truncated after %d bytes: %w", length, n, unexpectedEOF(err))}
+	}
+	return buff.String(), nil
+}
+
+// Decode an integer of the form i<digits>e
+func (d *BencodeDecoder) decodeInteger() (int, error) {
+	digits, start, err := d.readInteger()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(digits))
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, &SyntaxError{Offset: start, Err: fmt.Errorf("integer %s out of range", digits)}
+		}
+		return 0, &SyntaxError{Offset: start, Err: fmt.Errorf("invalid integer format %q", digits)}
+	}
+	return n, nil
+}
+
+// Decode an integer as an int64, falling back to a *big.Int for values outside of the int64 range
+func (d *BencodeDecoder) decodeInteger64() (interface{}, error) {
+	digits, start, err := d.readInteger()
+	if err != nil {
+		return nil, err
+	}
+	if !isInteger(digits) {
+		return nil, &SyntaxError{Offset: start, Err: fmt.Errorf("invalid integer format %q", digits)}
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err == nil {
+		return n, nil
+	}
+	big, ok := new(big.Int).SetString(string(digits), 10)
+	if !ok {
+		return nil, &SyntaxError{Offset: start, Err: fmt.Errorf("invalid integer format %q", digits)}
+	}
+	return big, nil
+}
+
+// Read the digits of an integer token, along with the offset where the token starts
+func (d *BencodeDecoder) readInteger() (digits []byte, start int64, err error) {
+	start = d.offset
+	if _, err := d.readByte(); err != nil {
+		return nil, start, err
+	}
+	digits, err = d.readUntil('e')
+	if err != nil {
+		return nil, start, err
+	}
+	if d.strict && isInteger(digits) {
+		unsigned := bytes.TrimPrefix(digits, []byte("-"))
+		if len(unsigned) > 1 && unsigned[0] == '0' {
+			return nil, start, &SyntaxError{Offset: start, Err: fmt.Errorf("%w in integer %q", ErrLeadingZero, digits)}
+		}
+		if digits[0] == '-' && unsigned[0] == '0' {
+			return nil, start, &SyntaxError{Offset: start, Err: ErrNegativeZero}
+		}
+	}
+	return digits, start, nil
+}
+
+// Check that the digits of an integer token are an optional minus sign followed by at least one digit
+func isInteger(digits []byte) bool {
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decode a list of the form l<values>e
+func (d *BencodeDecoder) decodeList() ([]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	var list []interface{}
+	for {
+		b, err := d.peekByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 'e' {
+			d.readByte()
+			return list, nil
+		}
+		element, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, element)
+	}
+}
+
+// Decode a dictionary of the form d<key><value>...e, keys must be byte strings
+func (d *BencodeDecoder) decodeDictionary() (map[string]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	dict := make(map[string]interface{})
+	previous := ""
+	for i := 0; ; i++ {
+		b, err := d.peekByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 'e' {
+			d.readByte()
+			return dict, nil
+		}
+		key, err := d.decodeKey(previous, i == 0)
+		if err != nil {
+			return nil, err
+		}
+		previous = key
+		value, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		dict[key] = value
+	}
+}
+
+// Decode a dictionary key, in strict mode keys must be unique and sorted as raw strings
+func (d *BencodeDecoder) decodeKey(previous string, first bool) (string, error) {
+	start := d.offset
+	b, err := d.peekByte()
+	if err != nil {
+		return "", err
+	}
+	if b < '0' || b > '9' {
+		return "", &SyntaxError{Offset: start, Err: fmt.Errorf("dictionary key must be a string, got %q", b)}
+	}
+	key, err := d.decodeString()
+	if err != nil {
+		return "", err
+	}
+	if d.strict && !first {
+		if key == previous {
+			return "", &SyntaxError{Offset: start, Err: fmt.Errorf("%w %q", ErrDuplicateKey, key)}
+		}
+		if key < previous {
+			return "", &SyntaxError{Offset: start, Err: fmt.Errorf("%w, %q after %q", ErrUnsortedKeys, key, previous)}
+		}
+	}
+	return key, nil
+}
+
+// Consume the opening byte of a list or dictionary and check the nesting depth
+func (d *BencodeDecoder) enter() error {
+	if d.depth >= MAX_NESTING_DEPTH {
+		return &SyntaxError{Offset: d.offset, Err: fmt.Errorf("nesting deeper than %d levels", MAX_NESTING_DEPTH)}
+	}
+	if _, err := d.readByte(); err != nil {
+		return err
+	}
+	d.depth++
+	return nil
+}
+
+func (d *BencodeDecoder) leave() {
+	d.depth--
+}
+
+func (d *BencodeDecoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0, &SyntaxError{Offset: d.offset, Err: unexpectedEOF(err)}
+	}
+	return b[0], nil
+}
+
+func (d *BencodeDecoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, &SyntaxError{Offset: d.offset, Err: unexpectedEOF(err)}
+	}
+	d.offset++
+	if d.capture != nil {
+		d.capture.WriteByte(b)
+	}
+	return b, nil
+}
+
+// Read a number up to and including delim, returning the bytes before it
+// Only digits and '-' are accepted, the caller checks where the sign is placed
+func (d *BencodeDecoder) readUntil(delim byte) ([]byte, error) {
+	start := d.offset
+	token := make([]byte, 0, 8)
+	for {
+		pos := d.offset
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == delim {
+			return token, nil
+		}
+		if (b < '0' || b > '9') && b != '-' {
+			return nil, &SyntaxError{Offset: pos, Err: fmt.Errorf("invalid character %q in number", b)}
+		}
+		if len(token) == MAX_NUMBER_DIGITS {
+			return nil, &SyntaxError{Offset: start, Err: fmt.Errorf("number longer than %d characters", MAX_NUMBER_DIGITS)}
+		}
+		token = append(token, b)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
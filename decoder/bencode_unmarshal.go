@@ -0,0 +1,305 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+// RawMessage holds the exact bytes of a bencoded value, see encoder.RawMessage
+type RawMessage = encoder.RawMessage
+
+// ByteString holds a byte string that may not be valid UTF-8, see encoder.ByteString
+type ByteString = encoder.ByteString
+
+// Unmarshaler is implemented by types that know how to decode their own bencoding
+type Unmarshaler interface {
+	UnmarshalBencode(data []byte) error
+}
+
+// UnmarshalTypeError describes a bencoded value that does not fit the Go type it is decoded into
+type UnmarshalTypeError struct {
+	Value  string // Kind of bencoded value: integer, string, list or dictionary
+	Type   reflect.Type
+	Offset int64
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("cannot unmarshal bencode %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(RawMessage(nil))
+	bigIntType      = reflect.TypeOf(big.Int{})
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// Unmarshal decodes the bencoded data into the value pointed to by v
+// It follows the same conventions as encoder.Marshal, dictionary keys without a matching struct field are skipped
+func Unmarshal(data []byte, v any) error {
+	return unmarshal(data, v, false)
+}
+
+// UnmarshalStrict is like Unmarshal but rejects every non-canonical encoding, see BencodeDecoder.SetStrict
+func UnmarshalStrict(data []byte, v any) error {
+	return unmarshal(data, v, true)
+}
+
+func unmarshal(data []byte, v any, strict bool) error {
+	d := NewBencodeDecoder(bytes.NewReader(data))
+	d.SetStrict(strict)
+	if err := d.DecodeInto(v); err != nil {
+		return err
+	}
+	if d.Offset() != int64(len(data)) {
+		return &SyntaxError{Offset: d.Offset(), Err: fmt.Errorf("unexpected data after the top-level value")}
+	}
+	return nil
+}
+
+// DecodeInto reads the next bencoded value from the stream and stores it in the value pointed to by v
+func (d *BencodeDecoder) DecodeInto(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot unmarshal into %T, a non-nil pointer is required", v)
+	}
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return io.EOF
+	}
+	return d.unmarshalValue(rv.Elem())
+}
+
+// DecodeRaw reads the next value from the stream and returns its exact bencoded bytes
+func (d *BencodeDecoder) DecodeRaw() (RawMessage, error) {
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	return d.readRaw()
+}
+
+// Read the next value and return its exact bencoded bytes
+func (d *BencodeDecoder) readRaw() ([]byte, error) {
+	outer := d.capture
+	buff := new(bytes.Buffer)
+	d.capture = buff
+	_, err := d.decodeValue()
+	d.capture = outer
+	if outer != nil {
+		outer.Write(buff.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (d *BencodeDecoder) unmarshalValue(v reflect.Value) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.unmarshalValue(v.Elem())
+	}
+	if v.Type() == rawMessageType {
+		raw, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		raw, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw)
+	}
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		value, err := d.decodeValue()
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+
+	b, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case b == 'i':
+		return d.unmarshalInteger(v)
+	case b == 'l':
+		return d.unmarshalList(v)
+	case b == 'd':
+		return d.unmarshalDictionary(v)
+	case b >= '0' && b <= '9':
+		return d.unmarshalString(v)
+	default:
+		return &SyntaxError{Offset: d.offset, Err: errUnsupportedType}
+	}
+}
+
+func (d *BencodeDecoder) unmarshalInteger(v reflect.Value) error {
+	digits, start, err := d.readInteger()
+	if err != nil {
+		return err
+	}
+	if !isInteger(digits) {
+		return &SyntaxError{Offset: start, Err: fmt.Errorf("invalid integer format %q", digits)}
+	}
+	// From here on the integer is well formed, parse errors mean it does not fit the target
+	typeErr := &UnmarshalTypeError{Value: "integer " + string(digits), Type: v.Type(), Offset: start}
+	if v.Type() == bigIntType {
+		v.Addr().Interface().(*big.Int).SetString(string(digits), 10)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(digits), 10, v.Type().Bits())
+		if err != nil {
+			return typeErr
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(digits), 10, v.Type().Bits())
+		if err != nil {
+			return typeErr
+		}
+		v.SetUint(n)
+	case reflect.Bool:
+		v.SetBool(string(digits) != "0" && string(digits) != "-0")
+	default:
+		return typeErr
+	}
+	return nil
+}
+
+func (d *BencodeDecoder) unmarshalString(v reflect.Value) error {
+	start := d.offset
+	s, err := d.decodeString()
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(s))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(s) != v.Len() {
+			return &UnmarshalTypeError{Value: fmt.Sprintf("string of length %d", len(s)), Type: v.Type(), Offset: start}
+		}
+		reflect.Copy(v, reflect.ValueOf([]byte(s)))
+	default:
+		return &UnmarshalTypeError{Value: "string", Type: v.Type(), Offset: start}
+	}
+	return nil
+}
+
+func (d *BencodeDecoder) unmarshalList(v reflect.Value) error {
+	start := d.offset
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return &UnmarshalTypeError{Value: "list", Type: v.Type(), Offset: start}
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	for i := 0; ; i++ {
+		b, err := d.peekByte()
+		if err != nil {
+			return err
+		}
+		if b == 'e' {
+			d.readByte()
+			if v.Kind() == reflect.Array && i != v.Len() {
+				return &UnmarshalTypeError{Value: fmt.Sprintf("list of length %d", i), Type: v.Type(), Offset: start}
+			}
+			return nil
+		}
+		if v.Kind() == reflect.Array {
+			if i >= v.Len() {
+				return &UnmarshalTypeError{Value: "list longer than the array", Type: v.Type(), Offset: start}
+			}
+			if err := d.unmarshalValue(v.Index(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		element := reflect.New(v.Type().Elem()).Elem()
+		if err := d.unmarshalValue(element); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, element))
+	}
+}
+
+func (d *BencodeDecoder) unmarshalDictionary(v reflect.Value) error {
+	start := d.offset
+	var fields map[string]encoder.Field
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case v.Kind() == reflect.Struct:
+		fields = make(map[string]encoder.Field)
+		for _, f := range encoder.TypeFields(v.Type()) {
+			fields[f.Name] = f
+		}
+	default:
+		return &UnmarshalTypeError{Value: "dictionary", Type: v.Type(), Offset: start}
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	previous := ""
+	for i := 0; ; i++ {
+		b, err := d.peekByte()
+		if err != nil {
+			return err
+		}
+		if b == 'e' {
+			d.readByte()
+			return nil
+		}
+		key, err := d.decodeKey(previous, i == 0)
+		if err != nil {
+			return err
+		}
+		previous = key
+		if v.Kind() == reflect.Map {
+			element := reflect.New(v.Type().Elem()).Elem()
+			if err := d.unmarshalValue(element); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), element)
+			continue
+		}
+		f, ok := fields[key]
+		if !ok {
+			// Unknown keys are skipped
+			if _, err := d.decodeValue(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.unmarshalValue(v.Field(f.Index)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+const (
+	EXTENDED_HANDSHAKE_ID = 0                // Extended message id of the extension handshake (BEP 10)
+	UT_METADATA           = "ut_metadata"    // Name of the metadata exchange extension (BEP 9)
+	METADATA_PIECE_LENGTH = 16 * 1024        // The info dictionary is exchanged in pieces of 16 kiB
+	MAX_METADATA_SIZE     = 16 * 1024 * 1024 // Largest info dictionary we accept from a peer
+)
+
+const ( // ut_metadata message types
+	METADATA_REQUEST = iota
+	METADATA_DATA
+	METADATA_REJECT
+)
+
+// ExtendedHandshake is the bencoded payload of the extension handshake
+// M maps the names of the extensions the sender supports to the extended message ids it wants to receive them with
+type ExtendedHandshake struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"` // Size of the info dictionary (BEP 9)
+	Reqq         int            `bencode:"reqq,omitempty"`          // Number of outstanding requests the sender accepts
+	V            string         `bencode:"v,omitempty"`             // Client name and version
+	YourIP       string         `bencode:"yourip,omitempty"`        // Compact IP address of the receiver as seen by the sender
+}
+
+// MetadataMessage is the bencoded header of a ut_metadata message, data messages are followed by the piece's data
+type MetadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// ExtendedMessage wraps the payload of an extension, extendedID is the id the receiving peer assigned to it
+func ExtendedMessage(extendedID uint8, payload []byte) *PeerMessage {
+	return NewPeerMessage(EXTENDED, append([]byte{extendedID}, payload...))
+}
+
+func ExtendedHandshakeMessage(h *ExtendedHandshake) (*PeerMessage, error) {
+	payload, err := encoder.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return ExtendedMessage(EXTENDED_HANDSHAKE_ID, payload), nil
+}
+
+// EncodeMetadataMessage returns the payload of a ut_metadata message, data is only sent with METADATA_DATA messages
+func EncodeMetadataMessage(m *MetadataMessage, data []byte) ([]byte, error) {
+	header, err := encoder.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, data...), nil
+}
+
+// DecodeExtendedMessage splits an EXTENDED message into its extended message id and payload
+func DecodeExtendedMessage(pm *PeerMessage) (extendedID uint8, payload []byte, err error) {
+	if pm.Id != EXTENDED || len(pm.Payload) < 1 {
+		return 0, nil, fmt.Errorf("invalid extended message %s", MessageNames[pm.Id])
+	}
+	return pm.Payload[0], pm.Payload[1:], nil
+}
+
+func DecodeExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	h := &ExtendedHandshake{}
+	if err := Unmarshal(payload, h); err != nil {
+		return nil, fmt.Errorf("invalid extended handshake: %v", err)
+	}
+	return h, nil
+}
+
+// DecodeMetadataMessage decodes a ut_metadata message, data holds the piece's data for METADATA_DATA messages
+func DecodeMetadataMessage(payload []byte) (m *MetadataMessage, data []byte, err error) {
+	d := NewBencodeDecoder(bytes.NewReader(payload))
+	m = &MetadataMessage{}
+	if err := d.DecodeInto(m); err != nil {
+		return nil, nil, fmt.Errorf("invalid ut_metadata message: %v", err)
+	}
+	return m, payload[d.Offset():], nil
+}
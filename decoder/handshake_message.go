@@ -0,0 +1,33 @@
+package decoder
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+const HANDSHAKE_LENGTH = 68 // 1 + 19 bytes of protocol identifier, 8 reserved bytes, 20 bytes of info hash and 20 of peer ID
+
+// HandshakeMessage is the first message a peer sends, see encoder.MakeHandshakeMessage for its layout
+type HandshakeMessage struct {
+	Reserved [8]byte
+	InfoHash string
+	PeerID   string
+}
+
+func DecodeHandshakeMessage(data []byte) (*HandshakeMessage, error) {
+	if len(data) != HANDSHAKE_LENGTH {
+		return nil, fmt.Errorf("invalid handshake length %d, expected %d", len(data), HANDSHAKE_LENGTH)
+	}
+	if int(data[0]) != len(encoder.PROTOCOL_IDENTIFIER) || string(data[1:20]) != encoder.PROTOCOL_IDENTIFIER {
+		return nil, fmt.Errorf("unexpected protocol identifier %q", data[1:20])
+	}
+	h := &HandshakeMessage{InfoHash: string(data[28:48]), PeerID: string(data[48:68])}
+	copy(h.Reserved[:], data[20:28])
+	return h, nil
+}
+
+// SupportsExtensions reports whether the peer set the extension protocol bit (BEP 10)
+func (h *HandshakeMessage) SupportsExtensions() bool {
+	return h.Reserved[5]&(1<<4) != 0
+}
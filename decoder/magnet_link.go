@@ -1,93 +1,228 @@
 package decoder
 
 import (
+	"encoding/base32"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+const (
+	BTIH_PREFIX      = "urn:btih:" // v1 info hash, 40 hex or 32 base32 characters
+	BTMH_PREFIX      = "urn:btmh:" // v2 info hash as a hex multihash (BEP 52)
+	SHA256_MULTIHASH = "1220"      // Multihash prefix of a 32-byte SHA-256 digest
+	MAX_SELECT_ONLY  = 1 << 16     // Largest number of files a so parameter may select
+)
+
+// MagnetLink holds the parameters of a magnet URI (BEP 9)
 type MagnetLink struct {
-	InfoHash    string
-	DisplayName string
-	Tracker     string
+	InfoHash    string   // Raw 20-byte info hash, the truncated InfoHashV2 for v2 only magnets
+	InfoHashV2  string   // Raw 32-byte SHA-256 info hash, empty for v1 only magnets
+	DisplayName string   // dn
+	Tracker     string   // First tracker, empty when there is none
+	Trackers    []string // tr, in the order they appear
+	WebSeeds    []string // ws (BEP 19)
+	ExactLength int      // xl, 0 when unknown
+	Peers       []string // x.pe, host:port addresses of peers to connect to directly
+	SelectOnly  []int    // so (BEP 53), sorted indices of the files to download, empty to download all of them
 }
 
 func NewMagnetLink(infoHash string, displayName string, tracker string) *MagnetLink {
-	return &MagnetLink{
+	m := &MagnetLink{
 		InfoHash:    infoHash,
 		DisplayName: displayName,
 		Tracker:     tracker,
 	}
+	if tracker != "" {
+		m.Trackers = []string{tracker}
+	}
+	return m
 }
 
 func (m *MagnetLink) String() string {
-	return fmt.Sprintf("MagnetLink{InfoHash: %s,\n DisplayName: %s,\n Tracker: %s\n}", m.InfoHash, m.DisplayName, m.Tracker)
+	return fmt.Sprintf("MagnetLink{InfoHash: %x,\n InfoHashV2: %x,\n DisplayName: %s,\n Trackers: %v,\n WebSeeds: %v,\n ExactLength: %d,\n Peers: %v,\n SelectOnly: %v\n}",
+		m.InfoHash, m.InfoHashV2, m.DisplayName, m.Trackers, m.WebSeeds, m.ExactLength, m.Peers, m.SelectOnly)
 }
 
-func ParseMagnetLink(magnetLink string) (*MagnetLink, error) {
-	// Parse the magnet link
-	// Example magnet link:
-	// magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&dn=magnet1.gif&tr=http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce
-	// These are the query parameters in a magnet link:
-
-	// xt: urn:btih: followed by the 40-char hex-encoded info hash (example: urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165)
-	// dn: The name of the file to be downloaded (example: magnet1.gif)
-	// tr: The tracker URL (example: http://bittorrent-test-tracker.codecrafters.io/announce)
-	const (
-		xt = "xt=urn:btih:"
-		dn = "dn="
-		tr = "tr="
-	)
+// Encode returns the magnet URI of the link, the reverse of ParseMagnetLink
+// Every value is query escaped, except the info hashes which only use URL safe characters
+func (m *MagnetLink) Encode() string {
+	params := []string{}
+	// The v1 info hash of a v2 only link is the truncated v2 info hash, peers would not find the torrent with it
+	if m.InfoHashV2 == "" || m.InfoHash != m.InfoHashV2[:20] {
+		params = append(params, fmt.Sprintf("xt=%s%x", BTIH_PREFIX, m.InfoHash))
+	}
+	if m.InfoHashV2 != "" {
+		params = append(params, fmt.Sprintf("xt=%s%s%x", BTMH_PREFIX, SHA256_MULTIHASH, m.InfoHashV2))
+	}
+	if m.DisplayName != "" {
+		params = append(params, "dn="+url.QueryEscape(m.DisplayName))
+	}
+	if m.ExactLength > 0 {
+		params = append(params, fmt.Sprintf("xl=%d", m.ExactLength))
+	}
+	for _, tracker := range m.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, webSeed := range m.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(webSeed))
+	}
+	for _, peer := range m.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(peer))
+	}
+	if len(m.SelectOnly) > 0 {
+		params = append(params, "so="+formatSelectOnly(m.SelectOnly))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
 
-	n := strings.Index(magnetLink, xt)
-	if n == -1 {
-		return nil, fmt.Errorf("error while parsing magnet link, could not find info hash")
+// ParseMagnetLink parses a magnet URI, parameters are percent-decoded and may be repeated
+// Example magnet link:
+// magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&dn=magnet1.gif&tr=http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce
+func ParseMagnetLink(magnetLink string) (*MagnetLink, error) {
+	rawQuery, ok := strings.CutPrefix(magnetLink, "magnet:?")
+	if !ok {
+		return nil, fmt.Errorf("error while parsing magnet link, expected it to start with magnet:?")
 	}
-	infoHashStr := magnetLink[n+len(xt) : n+len(xt)+40]
-	infoHash, err := hex.DecodeString(infoHashStr)
+	query, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		return nil, fmt.Errorf("error while decoding info hash: %v", err)
+		return nil, fmt.Errorf("error while parsing magnet link: %v", err)
 	}
 
-	displayName := ""
-	n = strings.Index(magnetLink, dn)
-	if n == -1 {
-		fmt.Printf("Did not find displayName in magnet link\n")
-	} else {
-		for i := n + len(dn); i < len(magnetLink); i++ {
-			if magnetLink[i] == '&' {
-				break
+	m := &MagnetLink{}
+	// xt is repeated for hybrid torrents, which have both a v1 and a v2 info hash
+	for _, xt := range query["xt"] {
+		if hash, ok := strings.CutPrefix(xt, BTIH_PREFIX); ok {
+			if m.InfoHash, err = decodeBTIH(hash); err != nil {
+				return nil, err
+			}
+		} else if hash, ok := strings.CutPrefix(xt, BTMH_PREFIX); ok {
+			if m.InfoHashV2, err = decodeBTMH(hash); err != nil {
+				return nil, err
 			}
-			displayName += string(magnetLink[i])
 		}
 	}
+	if m.InfoHash == "" && m.InfoHashV2 == "" {
+		return nil, fmt.Errorf("error while parsing magnet link, could not find info hash")
+	}
+	if m.InfoHash == "" {
+		m.InfoHash = m.InfoHashV2[:20]
+	}
 
-	n = strings.Index(magnetLink, tr)
-	tracker := ""
-	if n == -1 {
-		fmt.Printf("Did not find tracker in magnet link\n")
-	} else {
-		for i := n + len(tr); i < len(magnetLink); i++ {
-			if magnetLink[i] == '&' {
-				break
-			}
-			tracker += string(magnetLink[i])
+	m.DisplayName = query.Get("dn")
+	m.Trackers = nonEmpty(query["tr"])
+	if len(m.Trackers) > 0 {
+		m.Tracker = m.Trackers[0]
+	}
+	m.WebSeeds = nonEmpty(query["ws"])
+	if xl := query.Get("xl"); xl != "" {
+		if m.ExactLength, err = strconv.Atoi(xl); err != nil || m.ExactLength < 0 {
+			return nil, fmt.Errorf("error while parsing magnet link, invalid exact length %q", xl)
 		}
-		// Decode the tracker URL
-		tracker, _ = decodeURL(tracker)
 	}
+	for _, peer := range nonEmpty(query["x.pe"]) {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return nil, fmt.Errorf("error while parsing magnet link, invalid peer address %q: %v", peer, err)
+		}
+		m.Peers = append(m.Peers, peer)
+	}
+	if so := query.Get("so"); so != "" {
+		if m.SelectOnly, err = parseSelectOnly(so); err != nil {
+			return nil, fmt.Errorf("error while parsing magnet link, invalid file selection %q: %v", so, err)
+		}
+	}
+	return m, nil
+}
 
-	return NewMagnetLink(string(infoHash), displayName, tracker), nil
+// Decode a v1 info hash, either hex or base32 encoded
+func decodeBTIH(hash string) (string, error) {
+	var infoHash []byte
+	var err error
+	switch len(hash) {
+	case 40:
+		infoHash, err = hex.DecodeString(hash)
+	case 32:
+		infoHash, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+	default:
+		err = fmt.Errorf("expected 40 hex or 32 base32 characters, got %d", len(hash))
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while decoding info hash: %v", err)
+	}
+	return string(infoHash), nil
 }
 
-func decodeURL(addr string) (string, error) {
-	// Decode the URL
-	// Example: http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce
-	// should be decoded to: http://bittorrent-test-tracker.codecrafters.io/announce
-	decodedURL, err := url.QueryUnescape(addr)
+// Decode a v2 info hash, a hex encoded SHA-256 multihash
+func decodeBTMH(hash string) (string, error) {
+	digest, ok := strings.CutPrefix(strings.ToLower(hash), SHA256_MULTIHASH)
+	if !ok || len(digest) != 64 {
+		return "", fmt.Errorf("error while decoding v2 info hash: expected a SHA-256 multihash, got %q", hash)
+	}
+	infoHash, err := hex.DecodeString(digest)
 	if err != nil {
-		return "", fmt.Errorf("error while decoding URL: %v", err)
+		return "", fmt.Errorf("error while decoding v2 info hash: %v", err)
+	}
+	return string(infoHash), nil
+}
+
+// Parse a BEP 53 file selection such as 0,2,4-6 into sorted, unique file indices
+func parseSelectOnly(so string) ([]int, error) {
+	selected := map[int]bool{}
+	for _, part := range strings.Split(so, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid index %q", first)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		if end-start >= MAX_SELECT_ONLY-len(selected) {
+			return nil, fmt.Errorf("more than %d files selected", MAX_SELECT_ONLY)
+		}
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+	}
+	indices := make([]int, 0, len(selected))
+	for i := range selected {
+		indices = append(indices, i)
+	}
+	slices.Sort(indices)
+	return indices, nil
+}
+
+// Format sorted file indices as a BEP 53 file selection, consecutive indices are merged into ranges
+func formatSelectOnly(indices []int) string {
+	parts := []string{}
+	for i := 0; i < len(indices); {
+		j := i
+		for j+1 < len(indices) && indices[j+1] == indices[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(indices[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indices[i], indices[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func nonEmpty(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
 	}
-	return decodedURL, nil
+	return result
 }
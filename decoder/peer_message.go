@@ -19,27 +19,47 @@ func NewPeerMessage(id uint8, payload []byte) *PeerMessage {
 	}
 }
 
-func (d *Decoder) DecodePeerMessage() *PeerMessage {
-	length := d.readInt32()
-	if int(length) != len(d.data[d.offset:]) {
-		fmt.Printf("Error: length of message is %d but the remaining data is %d", length, len(d.data[d.offset:]))
-		return nil
+// DecodePeerMessage decodes a whole length-prefixed message, the data must hold exactly one message
+func (d *Decoder) DecodePeerMessage() (*PeerMessage, error) {
+	data := d.data[d.offset:]
+	if len(data) < 5 {
+		return nil, fmt.Errorf("peer message of %d bytes is too short", len(data))
 	}
-	id := d.readInt8()
-	payload := d.data[d.offset:]
-	return NewPeerMessage(uint8(id), payload)
+	length := binary.BigEndian.Uint32(data[0:4])
+	if int64(length) != int64(len(data)-4) {
+		return nil, fmt.Errorf("length of message is %d but the remaining data is %d", length, len(data)-4)
+	}
+	d.offset += len(data)
+	pm := NewPeerMessage(data[4], data[5:])
+	if err := pm.Validate(); err != nil {
+		return nil, err
+	}
+	return pm, nil
 }
 
-func DecodePieceMessage(data []byte) (pieceIndex, byteOffset int, dataBlock []byte) {
-	if len(data) < 13 {
-		fmt.Printf("Error: piece message is too short: %d", len(data))
-		return
+// Validate checks that the payload has the length the message id requires, unknown ids are accepted as is
+func (pm *PeerMessage) Validate() error {
+	var ok bool
+	switch pm.Id {
+	case CHOKE, UNCHOKE, INTERESTED, NOT_INTERESTED:
+		ok = len(pm.Payload) == 0
+	case HAVE:
+		ok = len(pm.Payload) == 4
+	case REQUEST, CANCEL:
+		ok = len(pm.Payload) == 12
+	case PIECE:
+		ok = len(pm.Payload) >= 8
+	case PORT:
+		ok = len(pm.Payload) == 2
+	case EXTENDED:
+		ok = len(pm.Payload) >= 1
+	default:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("invalid %s message with a payload of %d bytes", MessageNames[pm.Id], len(pm.Payload))
 	}
-	data = data[5:] // Skip the length and ID
-	pieceIndex = int(binary.BigEndian.Uint32(data[0:4]))
-	byteOffset = int(binary.BigEndian.Uint32(data[4:8]))
-	dataBlock = data[8:]
-	return
+	return nil
 }
 
 func (pm *PeerMessage) Encode() []byte {
@@ -51,16 +71,30 @@ func (pm *PeerMessage) Encode() []byte {
 }
 
 func (pm *PeerMessage) String() string {
+	name, ok := MessageNames[pm.Id]
+	if !ok {
+		name = fmt.Sprintf("UNKNOWN(%d)", pm.Id)
+	}
+	if err := pm.Validate(); err != nil {
+		return fmt.Sprintf("%s malformed payload of %d bytes", name, len(pm.Payload))
+	}
 	switch pm.Id {
-	case CHOKE, UNCHOKE, INTERESTED, NOT_INTERESTED:
-		return MessageNames[pm.Id]
-	case REQUEST:
-		pieceIndex := binary.BigEndian.Uint32(pm.Payload[0:4])
-		begin := binary.BigEndian.Uint32(pm.Payload[4:8])
-		length := binary.BigEndian.Uint32(pm.Payload[8:12])
-		return fmt.Sprintf("%s piece index: %d byte offset: %d length: %d\n", MessageNames[pm.Id], pieceIndex, begin, length)
+	case HAVE:
+		index, _ := ParseHave(pm)
+		return fmt.Sprintf("%s piece index: %d", name, index)
+	case REQUEST, CANCEL:
+		r, _ := ParseRequest(pm)
+		return fmt.Sprintf("%s piece index: %d byte offset: %d length: %d", name, r.Index, r.Begin, r.Length)
+	case PIECE:
+		b, _ := ParsePiece(pm)
+		return fmt.Sprintf("%s piece index: %d byte offset: %d length: %d", name, b.Index, b.Begin, len(b.Data))
+	case BITFIELD:
+		return fmt.Sprintf("%s of %d bytes", name, len(pm.Payload))
+	case PORT:
+		port, _ := ParsePort(pm)
+		return fmt.Sprintf("%s %d", name, port)
 	default:
-		return MessageNames[pm.Id]
+		return name
 	}
 }
 
@@ -74,6 +108,8 @@ const ( // Message types
 	REQUEST
 	PIECE
 	CANCEL
+	PORT          // DHT port of the peer (BEP 5)
+	EXTENDED = 20 // Extension protocol (BEP 10), the first payload byte is the extended message id
 )
 
 var MessageNames = map[uint8]string{
@@ -86,6 +122,8 @@ var MessageNames = map[uint8]string{
 	REQUEST:        "REQUEST",
 	PIECE:          "PIECE",
 	CANCEL:         "CANCEL",
+	PORT:           "PORT",
+	EXTENDED:       "EXTENDED",
 }
 
 func BitfieldMessage(payload []byte) *PeerMessage {
@@ -115,3 +153,102 @@ func RequestMessage(index, begin, length uint32) *PeerMessage {
 	binary.BigEndian.PutUint32(buff[8:12], length)
 	return NewPeerMessage(REQUEST, buff)
 }
+
+func HaveMessage(index uint32) *PeerMessage {
+	return NewPeerMessage(HAVE, binary.BigEndian.AppendUint32(nil, index))
+}
+
+func PieceMessage(index, begin uint32, block []byte) *PeerMessage {
+	buff := make([]byte, 8, 8+len(block))
+	binary.BigEndian.PutUint32(buff[0:4], index)
+	binary.BigEndian.PutUint32(buff[4:8], begin)
+	return NewPeerMessage(PIECE, append(buff, block...))
+}
+
+func CancelMessage(index, begin, length uint32) *PeerMessage {
+	pm := RequestMessage(index, begin, length)
+	pm.Id = CANCEL
+	return pm
+}
+
+func PortMessage(port uint16) *PeerMessage {
+	return NewPeerMessage(PORT, binary.BigEndian.AppendUint16(nil, port))
+}
+
+// BlockRequest is the payload of REQUEST and CANCEL messages
+type BlockRequest struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+// Block is the payload of a PIECE message
+type Block struct {
+	Index uint32
+	Begin uint32
+	Data  []byte
+}
+
+// Check the id and the payload length of a message before parsing it
+func checkMessage(pm *PeerMessage, ids ...uint8) error {
+	for _, id := range ids {
+		if pm.Id == id {
+			return pm.Validate()
+		}
+	}
+	return fmt.Errorf("expected a %s message, got %s", MessageNames[ids[0]], MessageNames[pm.Id])
+}
+
+// ParseHave returns the piece index of a HAVE message
+func ParseHave(pm *PeerMessage) (uint32, error) {
+	if err := checkMessage(pm, HAVE); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(pm.Payload), nil
+}
+
+// ParseRequest returns the block of a REQUEST or a CANCEL message
+func ParseRequest(pm *PeerMessage) (*BlockRequest, error) {
+	if err := checkMessage(pm, REQUEST, CANCEL); err != nil {
+		return nil, err
+	}
+	return &BlockRequest{
+		Index:  binary.BigEndian.Uint32(pm.Payload[0:4]),
+		Begin:  binary.BigEndian.Uint32(pm.Payload[4:8]),
+		Length: binary.BigEndian.Uint32(pm.Payload[8:12]),
+	}, nil
+}
+
+// ParsePiece returns the block of a PIECE message, its data shares the message's payload
+func ParsePiece(pm *PeerMessage) (*Block, error) {
+	if err := checkMessage(pm, PIECE); err != nil {
+		return nil, err
+	}
+	return &Block{
+		Index: binary.BigEndian.Uint32(pm.Payload[0:4]),
+		Begin: binary.BigEndian.Uint32(pm.Payload[4:8]),
+		Data:  pm.Payload[8:],
+	}, nil
+}
+
+// ParseBitfield checks the BITFIELD of a torrent of pieceCount pieces, the spare bits at the end must be cleared
+func ParseBitfield(pm *PeerMessage, pieceCount int) ([]byte, error) {
+	if err := checkMessage(pm, BITFIELD); err != nil {
+		return nil, err
+	}
+	if len(pm.Payload) != (pieceCount+7)/8 {
+		return nil, fmt.Errorf("bitfield of %d bytes for %d pieces", len(pm.Payload), pieceCount)
+	}
+	if spare := pieceCount % 8; spare != 0 && pm.Payload[len(pm.Payload)-1]&(0xff>>spare) != 0 {
+		return nil, fmt.Errorf("bitfield has spare bits set")
+	}
+	return pm.Payload, nil
+}
+
+// ParsePort returns the DHT port of a PORT message
+func ParsePort(pm *PeerMessage) (uint16, error) {
+	if err := checkMessage(pm, PORT); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(pm.Payload), nil
+}
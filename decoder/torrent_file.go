@@ -2,17 +2,41 @@ package decoder
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
 	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
 type TorrentFile struct {
-	Announce    string
-	Length      int
-	InfoHash    string
-	PieceLength int
-	PieceHashes []string
+	Announce     string
+	AnnounceList [][]string // Tiers of tracker URLs (BEP 12), empty when the torrent only has Announce
+	Name         string     // Suggested file name, or directory name for multi-file torrents
+	Length       int        // Total length of all the files
+	InfoHash     string
+	PieceLength  int
+	PieceHashes  []string
+	Files        []FileEntry // Files in the order they are laid out in the pieces, a single entry for single-file torrents
+	MultiFile    bool
+	Private      bool     // Peers must only come from the torrent's trackers (BEP 27)
+	WebSeeds     []string // HTTP servers hosting the torrent's data (BEP 19)
+
+	// BitTorrent v2 (BEP 52), set for v2 and hybrid torrents
+	// InfoHash is the truncated InfoHashV2 for v2 only torrents since the wire protocol uses 20-byte hashes
+	MetaVersion int
+	InfoHashV2  string            // SHA-256 of the info dictionary
+	PieceLayers map[string]string // Pieces root -> concatenated SHA-256 piece hashes, for files larger than a piece
+	v2Pieces    []v2Piece         // Layout of the pieces of v2 only torrents, each file starts on a new piece
+}
+
+// FileEntry is a file of a torrent, its data starts at Offset in the concatenation of all the files
+type FileEntry struct {
+	Path       []string // Path components relative to the torrent's directory, validated by utils.SafeJoin
+	Length     int
+	Offset     int
+	Padding    bool   // Padding file of a hybrid torrent (BEP 47), its zeroes are not written to disk
+	PiecesRoot string // Merkle root of the file for v2 torrents, empty for empty files
 }
 
 func NewTorrentFile(announce string, length int, infoHash string, pieceLength int, pieceHashes []string) *TorrentFile {
@@ -30,53 +54,193 @@ func (t *TorrentFile) String() string {
 	for _, h := range t.PieceHashes {
 		hashes += h + "\n"
 	}
-	return fmt.Sprintf("Tracker URL: %s\nLength: %d\nInfo Hash: %x\nPiece Length: %d\nPiece Hashes:\n%s", t.Announce, t.Length, t.InfoHash, t.PieceLength, hashes)
+	info := fmt.Sprintf("Tracker URL: %s\nLength: %d\nInfo Hash: %x\nPiece Length: %d\nPiece Hashes:\n%s", t.Announce, t.Length, t.InfoHash, t.PieceLength, hashes)
+	if t.MetaVersion == 2 {
+		info += fmt.Sprintf("Meta Version: 2\nInfo Hash v2: %x\n", t.InfoHashV2)
+	}
+	if len(t.AnnounceList) > 0 {
+		info += "Tracker Tiers:\n"
+		for i, tier := range t.AnnounceList {
+			info += fmt.Sprintf("%d: %s\n", i, strings.Join(tier, " "))
+		}
+	}
+	if len(t.WebSeeds) > 0 {
+		info += fmt.Sprintf("Web Seeds: %s\n", strings.Join(t.WebSeeds, " "))
+	}
+	if t.MultiFile {
+		info += fmt.Sprintf("Name: %s\nFiles:\n", t.Name)
+		for _, f := range t.Files {
+			if !f.Padding {
+				info += fmt.Sprintf("%d %s\n", f.Length, strings.Join(f.Path, "/"))
+			}
+		}
+	}
+	return info
+}
+
+// Trackers returns the tiers of trackers to announce to
+// Per BEP 12 the announce-list takes precedence over announce when it is present
+func (t *TorrentFile) Trackers() [][]string {
+	tiers := make([][]string, 0, len(t.AnnounceList))
+	for _, tier := range t.AnnounceList {
+		urls := make([]string, 0, len(tier))
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	if len(tiers) == 0 && t.Announce != "" {
+		tiers = append(tiers, []string{t.Announce})
+	}
+	return tiers
 }
 
+// The bencoded layout of .torrent files is shared with the encoder, which builds them
+type (
+	MetaInfo      = encoder.MetaInfo
+	InfoDict      = encoder.InfoDict
+	InfoFile      = encoder.InfoFile
+	FileTreeEntry = encoder.FileTreeEntry
+)
+
 // A Torrent file is a bencoded dictionary containing information about the torrent
 func DecodeTorrentFile(fileContent string) (t *TorrentFile, bytesRead int, err error) {
-	decoded, _, err := decodeDictionary(fileContent)
-	if err != nil {
+	var meta MetaInfo
+	if err := Unmarshal([]byte(fileContent), &meta); err != nil {
 		return nil, 0, fmt.Errorf("error decoding torrent file: %v", err)
 	}
-	URL, ok := decoded["announce"].(string)
-	if !ok {
-		fmt.Println("No tracker URL found")
-		return
+	bytesRead = len(fileContent)
+	if meta.Announce == "" && len(meta.AnnounceList) == 0 {
+		return nil, bytesRead, fmt.Errorf("no tracker URL found")
+	}
+	t, err = TorrentFileFromMetaInfo(&meta)
+	if err != nil {
+		return nil, bytesRead, err
+	}
+	return t, bytesRead, nil
+}
+
+// TorrentFileFromMetaInfo builds a torrent from its metainfo, it does not require any tracker
+// Torrents fetched from magnet links have no .torrent file, only an info dictionary and the magnet's trackers
+func TorrentFileFromMetaInfo(meta *MetaInfo) (*TorrentFile, error) {
+	if len(meta.Info) == 0 {
+		return nil, fmt.Errorf("no info found")
+	}
+	var info InfoDict
+	if err := Unmarshal(meta.Info, &info); err != nil {
+		return nil, fmt.Errorf("error decoding info dictionary: %v", err)
+	}
+	if info.PieceLength <= 0 {
+		return nil, fmt.Errorf("no piece length found")
+	}
+	if _, err := utils.SafeJoin("", info.Name); err != nil {
+		return nil, fmt.Errorf("invalid name: %v", err)
 	}
-	info, ok := decoded["info"].(map[string]interface{})
-	if !ok {
-		fmt.Println("No info found")
-		return
+	hasV1, hasV2 := info.Pieces != "", info.MetaVersion == 2
+	if !hasV1 && !hasV2 {
+		return nil, fmt.Errorf("no pieces found and meta version %d is not supported", info.MetaVersion)
 	}
-	infoBencoded, err := encoder.EncodeBencode(info)
+
+	t := &TorrentFile{
+		Announce:     meta.Announce,
+		AnnounceList: meta.AnnounceList,
+		Name:         info.Name,
+		PieceLength:  info.PieceLength,
+		Private:      info.Private == 1,
+	}
+	webSeeds, err := parseWebSeeds(meta.URLList)
+	if err != nil {
+		return nil, err
+	}
+	t.WebSeeds = webSeeds
+	if hasV1 {
+		// The info hash is the SHA1 of the info dictionary exactly as it appears in the file
+		// Re-encoding it would change the hash of torrents whose info dictionary is not canonical
+		t.InfoHash = utils.SHA1Hash(meta.Info)
+		if err := t.parseV1(&info); err != nil {
+			return nil, err
+		}
+	}
+	if hasV2 {
+		t.MetaVersion = 2
+		t.InfoHashV2 = utils.SHA256Hash(meta.Info)
+		if err := t.parseV2(&info, meta.PieceLayers, hasV1); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// The url-list of a torrent is either a single URL or a list of URLs
+func parseWebSeeds(urlList RawMessage) ([]string, error) {
+	if len(urlList) == 0 {
+		return nil, nil
+	}
+	var webSeeds []string
+	if urlList[0] == 'l' {
+		if err := Unmarshal(urlList, &webSeeds); err != nil {
+			return nil, fmt.Errorf("invalid url-list: %v", err)
+		}
+	} else {
+		var webSeed string
+		if err := Unmarshal(urlList, &webSeed); err != nil {
+			return nil, fmt.Errorf("invalid url-list: %v", err)
+		}
+		webSeeds = []string{webSeed}
+	}
+	return slices.DeleteFunc(webSeeds, func(u string) bool { return u == "" }), nil
+}
+
+// Fill the files, length and SHA1 piece hashes from the v1 part of the info dictionary
+func (t *TorrentFile) parseV1(info *InfoDict) error {
+	files, length, err := fileEntries(info)
 	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	infoHash := utils.SHA1Hash([]byte(infoBencoded))
-	length, ok := info["length"].(int)
-	if !ok {
-		fmt.Println("No length found")
-		return
-	}
-	pieceLength, ok := info["piece length"].(int)
-	if !ok {
-		fmt.Println("No piece length found")
-		return
-	}
-	pieces, ok := info["pieces"].(string)
-	if !ok {
-		fmt.Println("No pieces found")
-		return
-	}
-	pieceHashes := make([]string, 0)
-	for i := 0; i < len(pieces); i += 20 {
-		if i+20 > len(pieces) {
-			fmt.Println("Invalid pieces length")
-			return
+		return err
+	}
+	if len(info.Pieces)%20 != 0 {
+		return fmt.Errorf("invalid pieces length %d, expected a multiple of 20", len(info.Pieces))
+	}
+	pieceHashes := make([]string, 0, len(info.Pieces)/20)
+	for i := 0; i < len(info.Pieces); i += 20 {
+		pieceHashes = append(pieceHashes, fmt.Sprintf("%x", info.Pieces[i:i+20]))
+	}
+	if len(pieceHashes) != (length+info.PieceLength-1)/info.PieceLength {
+		return fmt.Errorf("expected %d piece hashes for a length of %d, got %d", (length+info.PieceLength-1)/info.PieceLength, length, len(pieceHashes))
+	}
+	t.Length = length
+	t.PieceHashes = pieceHashes
+	t.Files = files
+	t.MultiFile = len(info.Files) > 0
+	return nil
+}
+
+// List the files of the info dictionary with their offsets, along with the total length
+// Paths are validated so they cannot escape the directory the torrent is downloaded to
+func fileEntries(info *InfoDict) ([]FileEntry, int, error) {
+	if len(info.Files) == 0 {
+		if info.Length <= 0 {
+			return nil, 0, fmt.Errorf("no length found")
+		}
+		return []FileEntry{{Path: []string{info.Name}, Length: info.Length}}, info.Length, nil
+	}
+	files := make([]FileEntry, 0, len(info.Files))
+	offset := 0
+	for _, f := range info.Files {
+		if f.Length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %d for file %v", f.Length, f.Path)
+		}
+		if _, err := utils.SafeJoin("", f.Path...); err != nil {
+			return nil, 0, fmt.Errorf("invalid file path %q: %v", f.Path, err)
 		}
-		pieceHashes = append(pieceHashes, fmt.Sprintf("%x", pieces[i:i+20]))
+		files = append(files, FileEntry{Path: f.Path, Length: f.Length, Offset: offset, Padding: strings.Contains(f.Attr, "p")})
+		offset += f.Length
+	}
+	if offset == 0 {
+		return nil, 0, fmt.Errorf("no length found")
 	}
-	return NewTorrentFile(URL, length, infoHash, pieceLength, pieceHashes), bytesRead, nil
+	return files, offset, nil
 }
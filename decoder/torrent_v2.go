@@ -0,0 +1,267 @@
+package decoder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+const MERKLE_BLOCK_LENGTH = 16 * 1024 // Size of the leaves of the v2 merkle trees
+
+// A piece of a v2 only torrent, pieces never span two files
+type v2Piece struct {
+	file        int // Index in Files
+	pieceInFile int
+	length      int
+}
+
+// Fill the v2 fields from the file tree and the piece layers
+// For hybrid torrents the v1 file list is kept and each of its files is matched with the file tree
+func (t *TorrentFile) parseV2(info *InfoDict, pieceLayers map[string]string, hybrid bool) error {
+	if t.PieceLength < MERKLE_BLOCK_LENGTH || t.PieceLength&(t.PieceLength-1) != 0 {
+		return fmt.Errorf("v2 piece length %d must be a power of two of at least %d", t.PieceLength, MERKLE_BLOCK_LENGTH)
+	}
+	if len(info.FileTree) == 0 {
+		return fmt.Errorf("no file tree found")
+	}
+	files := make([]FileEntry, 0)
+	if err := parseFileTree(info.FileTree, nil, &files); err != nil {
+		return fmt.Errorf("invalid file tree: %v", err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("empty file tree")
+	}
+	t.PieceLayers = pieceLayers
+	for _, f := range files {
+		if err := t.checkPieceLayer(f, hybrid); err != nil {
+			return err
+		}
+	}
+
+	if hybrid {
+		return t.matchV1Files(files)
+	}
+	t.InfoHash = t.InfoHashV2[:20]
+	t.MultiFile = len(files) > 1 || !slices.Equal(files[0].Path, []string{t.Name})
+	if !t.MultiFile {
+		files[0].Path = []string{t.Name}
+	}
+	offset := 0
+	for i := range files {
+		files[i].Offset = offset
+		offset += files[i].Length
+		for p := 0; p*t.PieceLength < files[i].Length; p++ {
+			t.v2Pieces = append(t.v2Pieces, v2Piece{
+				file:        i,
+				pieceInFile: p,
+				length:      min(t.PieceLength, files[i].Length-p*t.PieceLength),
+			})
+		}
+	}
+	t.Length = offset
+	t.Files = files
+	return nil
+}
+
+// Walk a v2 file tree in key order, a file is a dictionary whose empty key holds its length and pieces root
+func parseFileTree(raw RawMessage, path []string, files *[]FileEntry) error {
+	var node map[string]RawMessage
+	if err := Unmarshal(raw, &node); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if k != "" {
+			if err := parseFileTree(node[k], append(slices.Clip(path), k), files); err != nil {
+				return err
+			}
+			continue
+		}
+		var entry FileTreeEntry
+		if err := Unmarshal(node[k], &entry); err != nil {
+			return err
+		}
+		if _, err := utils.SafeJoin("", path...); err != nil {
+			return fmt.Errorf("invalid file path %q: %v", path, err)
+		}
+		if entry.Length < 0 || (entry.Length > 0 && len(entry.PiecesRoot) != 32) {
+			return fmt.Errorf("invalid entry for file %q", path)
+		}
+		*files = append(*files, FileEntry{Path: path, Length: entry.Length, PiecesRoot: entry.PiecesRoot})
+	}
+	return nil
+}
+
+// Check that the piece layer of a file larger than a piece hashes up to the file's pieces root
+// Hybrid torrents may come without piece layers, as when their info dictionary is fetched from peers, they are then
+// only verified with their v1 piece hashes
+func (t *TorrentFile) checkPieceLayer(f FileEntry, hybrid bool) error {
+	if f.Length <= t.PieceLength {
+		return nil
+	}
+	layer, ok := t.PieceLayers[f.PiecesRoot]
+	if !ok && hybrid {
+		return nil
+	}
+	numPieces := (f.Length + t.PieceLength - 1) / t.PieceLength
+	if !ok || len(layer) != numPieces*32 {
+		return fmt.Errorf("missing or invalid piece layer for file %q", strings.Join(f.Path, "/"))
+	}
+	hashes := make([]string, 0, numPieces)
+	for i := 0; i < len(layer); i += 32 {
+		hashes = append(hashes, layer[i:i+32])
+	}
+	padding := merkleRoot(nil, t.PieceLength/MERKLE_BLOCK_LENGTH, zeroHash)
+	if merkleRoot(hashes, nextPowerOfTwo(numPieces), padding) != f.PiecesRoot {
+		return fmt.Errorf("piece layer of file %q does not match its pieces root", strings.Join(f.Path, "/"))
+	}
+	return nil
+}
+
+// Attach the pieces roots of the file tree to the v1 files of a hybrid torrent, both must list the same files
+func (t *TorrentFile) matchV1Files(v2Files []FileEntry) error {
+	next := 0
+	for i := range t.Files {
+		f := &t.Files[i]
+		if f.Padding {
+			continue
+		}
+		if next >= len(v2Files) {
+			return fmt.Errorf("file %q is missing from the v2 file tree", strings.Join(f.Path, "/"))
+		}
+		v2File := v2Files[next]
+		next++
+		if !t.MultiFile {
+			v2File.Path = f.Path
+		}
+		if !slices.Equal(f.Path, v2File.Path) || f.Length != v2File.Length {
+			return fmt.Errorf("v1 file %q does not match v2 file %q", strings.Join(f.Path, "/"), strings.Join(v2File.Path, "/"))
+		}
+		if f.Length > 0 && f.Offset%t.PieceLength != 0 {
+			return fmt.Errorf("file %q is not aligned to a piece boundary", strings.Join(f.Path, "/"))
+		}
+		f.PiecesRoot = v2File.PiecesRoot
+	}
+	if next != len(v2Files) {
+		return fmt.Errorf("the v2 file tree has %d files, the v1 file list %d", len(v2Files), next)
+	}
+	return nil
+}
+
+// PieceCount returns the number of pieces of the torrent
+func (t *TorrentFile) PieceCount() int {
+	if len(t.PieceHashes) > 0 {
+		return len(t.PieceHashes)
+	}
+	return len(t.v2Pieces)
+}
+
+// PieceSize returns the length of a piece, only the last piece of a file may be shorter than PieceLength
+func (t *TorrentFile) PieceSize(index int) int {
+	if len(t.PieceHashes) == 0 {
+		return t.v2Pieces[index].length
+	}
+	if index == len(t.PieceHashes)-1 && t.Length%t.PieceLength != 0 {
+		return t.Length % t.PieceLength
+	}
+	return t.PieceLength
+}
+
+// VerifyPiece checks the data of a piece against the SHA1 piece hashes and, for v2 torrents, against the merkle trees
+func (t *TorrentFile) VerifyPiece(index int, data []byte) error {
+	if index < 0 || index >= t.PieceCount() {
+		return fmt.Errorf("invalid piece index %d", index)
+	}
+	if len(data) != t.PieceSize(index) {
+		return fmt.Errorf("piece %d has %d bytes, expected %d", index, len(data), t.PieceSize(index))
+	}
+	if len(t.PieceHashes) > 0 {
+		if hash := fmt.Sprintf("%x", utils.SHA1Hash(data)); hash != t.PieceHashes[index] {
+			return fmt.Errorf("piece %d hash does not match the piece hash in the torrent file, expected: %s, got: %s", index, t.PieceHashes[index], hash)
+		}
+	}
+	if t.MetaVersion == 2 {
+		return t.verifyPieceV2(index, data)
+	}
+	return nil
+}
+
+func (t *TorrentFile) verifyPieceV2(index int, data []byte) error {
+	f, pieceInFile, ok := t.v2PieceFile(index)
+	if !ok {
+		// Hybrid torrent piece made of padding only
+		return nil
+	}
+	data = data[:min(len(data), f.Length-pieceInFile*t.PieceLength)]
+	leaves := make([]string, 0, (len(data)+MERKLE_BLOCK_LENGTH-1)/MERKLE_BLOCK_LENGTH)
+	for i := 0; i < len(data); i += MERKLE_BLOCK_LENGTH {
+		leaves = append(leaves, utils.SHA256Hash(data[i:min(i+MERKLE_BLOCK_LENGTH, len(data))]))
+	}
+	var expected, root string
+	layer, hasLayer := t.PieceLayers[f.PiecesRoot]
+	if f.Length > t.PieceLength && !hasLayer {
+		// Hybrid torrent without piece layers, the SHA1 piece hash was checked already
+		return nil
+	}
+	if f.Length <= t.PieceLength {
+		// A file that fits in a piece is verified against its pieces root directly
+		expected = f.PiecesRoot
+		root = merkleRoot(leaves, nextPowerOfTwo(len(leaves)), zeroHash)
+	} else {
+		expected = layer[pieceInFile*32 : pieceInFile*32+32]
+		root = merkleRoot(leaves, t.PieceLength/MERKLE_BLOCK_LENGTH, zeroHash)
+	}
+	if root != expected {
+		return fmt.Errorf("piece %d does not match the merkle tree of file %q", index, strings.Join(f.Path, "/"))
+	}
+	return nil
+}
+
+// Find the file a piece belongs to along with the index of the piece within the file
+func (t *TorrentFile) v2PieceFile(index int) (*FileEntry, int, bool) {
+	if len(t.PieceHashes) == 0 {
+		p := t.v2Pieces[index]
+		return &t.Files[p.file], p.pieceInFile, true
+	}
+	offset := index * t.PieceLength
+	for i := range t.Files {
+		f := &t.Files[i]
+		if !f.Padding && f.Offset <= offset && offset < f.Offset+f.Length {
+			return f, (offset - f.Offset) / t.PieceLength, true
+		}
+	}
+	return nil, 0, false
+}
+
+var zeroHash = string(make([]byte, 32))
+
+// Compute the root of a merkle tree of SHA-256 hashes with width leaves, missing leaves are set to padding
+func merkleRoot(leaves []string, width int, padding string) string {
+	layer := make([]string, width)
+	copy(layer, leaves)
+	for i := len(leaves); i < width; i++ {
+		layer[i] = padding
+	}
+	for len(layer) > 1 {
+		next := make([]string, len(layer)/2)
+		for i := range next {
+			next[i] = utils.SHA256Hash([]byte(layer[2*i] + layer[2*i+1]))
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
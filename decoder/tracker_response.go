@@ -0,0 +1,134 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// TrackerResponse mirrors the bencoded dictionary returned by a tracker announce
+// Peers is either a compact string (BEP 23) or a list of dictionaries (BEP 3), Peers6 is always compact (BEP 7)
+type TrackerResponse struct {
+	FailureReason  string     `bencode:"failure reason,omitempty"`
+	WarningMessage string     `bencode:"warning message,omitempty"`
+	Interval       int        `bencode:"interval,omitempty"`
+	MinInterval    int        `bencode:"min interval,omitempty"`
+	TrackerID      string     `bencode:"tracker id,omitempty"`
+	Complete       int        `bencode:"complete,omitempty"`   // Seeders
+	Incomplete     int        `bencode:"incomplete,omitempty"` // Leechers
+	Peers          RawMessage `bencode:"peers,omitempty"`
+	Peers6         string     `bencode:"peers6,omitempty"`
+}
+
+// Peer is a peer returned by a tracker, ID is empty unless the tracker sent it
+// Host is the host:port of a dictionary peer whose ip is a DNS name, Addr is then the zero value
+type Peer struct {
+	Addr netip.AddrPort
+	Host string
+	ID   string
+}
+
+// String returns the host:port address to connect to the peer
+func (p Peer) String() string {
+	if p.Host != "" {
+		return p.Host
+	}
+	return p.Addr.String()
+}
+
+// A peer of the dictionary model of tracker responses
+type dictPeer struct {
+	ID   string `bencode:"peer id,omitempty"`
+	IP   string `bencode:"ip"`
+	Port int    `bencode:"port"`
+}
+
+// PeerList returns the IPv4 and IPv6 peers of the response, whatever model the tracker used
+// Dictionary peers whose ip is a DNS name are kept by name, the ones without a valid ip or port are skipped
+func (r *TrackerResponse) PeerList() ([]Peer, error) {
+	var peers []Peer
+	switch {
+	case len(r.Peers) == 0:
+	case r.Peers[0] == 'l':
+		var dictPeers []dictPeer
+		if err := Unmarshal(r.Peers, &dictPeers); err != nil {
+			return nil, fmt.Errorf("invalid peers list: %v", err)
+		}
+		for _, p := range dictPeers {
+			if p.Port <= 0 || p.Port > 0xffff {
+				continue
+			}
+			if addr, err := netip.ParseAddr(p.IP); err == nil {
+				peers = append(peers, Peer{Addr: netip.AddrPortFrom(addr.Unmap(), uint16(p.Port)), ID: p.ID})
+			} else if isHostName(p.IP) {
+				peers = append(peers, Peer{Host: net.JoinHostPort(p.IP, strconv.Itoa(p.Port)), ID: p.ID})
+			}
+		}
+	default:
+		var compact string
+		if err := Unmarshal(r.Peers, &compact); err != nil {
+			return nil, fmt.Errorf("invalid peers: %v", err)
+		}
+		compactPeers, err := ParseCompactPeers(compact, 4)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, compactPeers...)
+	}
+	peers6, err := ParseCompactPeers(r.Peers6, 16)
+	if err != nil {
+		return nil, err
+	}
+	return append(peers, peers6...), nil
+}
+
+// Whether name is made of DNS labels: letters, digits and hyphens separated by dots
+func isHostName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParseCompactPeers parses peers made of an IP address of addrLength bytes followed by a big endian port
+func ParseCompactPeers(compact string, addrLength int) ([]Peer, error) {
+	entryLength := addrLength + 2
+	if len(compact)%entryLength != 0 {
+		return nil, fmt.Errorf("compact peers of %d bytes is not a multiple of %d", len(compact), entryLength)
+	}
+	peers := make([]Peer, 0, len(compact)/entryLength)
+	for i := 0; i < len(compact); i += entryLength {
+		addr, ok := netip.AddrFromSlice([]byte(compact[i : i+addrLength]))
+		if !ok {
+			return nil, fmt.Errorf("invalid address of %d bytes", addrLength)
+		}
+		port := binary.BigEndian.Uint16([]byte(compact[i+addrLength : i+entryLength]))
+		peers = append(peers, Peer{Addr: netip.AddrPortFrom(addr, port)})
+	}
+	return peers, nil
+}
+
+// ScrapeResponse mirrors the bencoded dictionary returned by a tracker scrape, files are keyed by raw info hash
+type ScrapeResponse struct {
+	FailureReason string                `bencode:"failure reason,omitempty"`
+	Files         map[string]ScrapeFile `bencode:"files"`
+}
+
+type ScrapeFile struct {
+	Complete   int `bencode:"complete"`   // Seeders
+	Downloaded int `bencode:"downloaded"` // Number of completed downloads
+	Incomplete int `bencode:"incomplete"` // Leechers
+}
@@ -0,0 +1,219 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RawMessage is an already bencoded value, it is written as is by Marshal and filled with the exact input bytes by Unmarshal
+type RawMessage []byte
+
+// Marshaler is implemented by types that know how to bencode themselves
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// UnsupportedTypeError is returned by Marshal when a value has no bencode representation
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Type == nil {
+		return ErrUnsupportedBencodeType + ": nil"
+	}
+	return ErrUnsupportedBencodeType + ": " + e.Type.String()
+}
+
+var (
+	rawMessageType = reflect.TypeOf(RawMessage(nil))
+	bigIntType     = reflect.TypeOf(big.Int{})
+	marshalerType  = reflect.TypeOf((*Marshaler)(nil)).Elem()
+)
+
+// Marshal returns the bencoding of v
+// Strings, byte slices and byte arrays become byte strings, integers, big.Int and booleans become integers,
+// slices and arrays become lists, maps with string keys and structs become dictionaries
+// Struct fields are named by their `bencode:"name,omitempty"` tag, a "-" name skips the field
+// Nil pointers and interfaces are left out of dictionaries since bencode has no null value
+func Marshal(v any) ([]byte, error) {
+	buff := new(bytes.Buffer)
+	if err := marshalValue(buff, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func marshalValue(buff *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return &UnsupportedTypeError{}
+	}
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return fmt.Errorf("cannot marshal an empty RawMessage")
+		}
+		buff.Write(v.Bytes())
+		return nil
+	}
+	if v.Type().Implements(marshalerType) && !(v.Kind() == reflect.Pointer && v.IsNil()) {
+		b, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return err
+		}
+		buff.Write(b)
+		return nil
+	}
+	if v.Type() == bigIntType {
+		// Arbitrary precision integers, the value is copied when it is not addressable
+		if !v.CanAddr() {
+			addressable := reflect.New(bigIntType).Elem()
+			addressable.Set(v)
+			v = addressable
+		}
+		buff.WriteString("i" + v.Addr().Interface().(*big.Int).String() + "e")
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return &UnsupportedTypeError{Type: v.Type()}
+		}
+		return marshalValue(buff, v.Elem())
+	case reflect.String:
+		writeByteString(buff, v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			buff.WriteString("i1e")
+		} else {
+			buff.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buff.WriteString("i" + strconv.FormatInt(v.Int(), 10) + "e")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buff.WriteString("i" + strconv.FormatUint(v.Uint(), 10) + "e")
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			writeByteString(buff, string(b))
+			return nil
+		}
+		buff.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if err := marshalValue(buff, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buff.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return &UnsupportedTypeError{Type: v.Type()}
+		}
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		slices.Sort(keys)
+		buff.WriteByte('d')
+		for _, k := range keys {
+			value := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+			if isNil(value) {
+				continue
+			}
+			writeByteString(buff, k)
+			if err := marshalValue(buff, value); err != nil {
+				return err
+			}
+		}
+		buff.WriteByte('e')
+	case reflect.Struct:
+		buff.WriteByte('d')
+		for _, f := range TypeFields(v.Type()) {
+			value := v.Field(f.Index)
+			if isNil(value) || (f.OmitEmpty && isEmptyValue(value)) {
+				continue
+			}
+			writeByteString(buff, f.Name)
+			if err := marshalValue(buff, value); err != nil {
+				return err
+			}
+		}
+		buff.WriteByte('e')
+	default:
+		return &UnsupportedTypeError{Type: v.Type()}
+	}
+	return nil
+}
+
+func writeByteString(buff *bytes.Buffer, s string) {
+	buff.WriteString(strconv.Itoa(len(s)))
+	buff.WriteByte(':')
+	buff.WriteString(s)
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// Field describes how a struct field maps to a dictionary key
+type Field struct {
+	Name      string
+	Index     int
+	OmitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]Field
+
+// TypeFields returns the bencoded fields of a struct type, sorted by key as bencode dictionaries require
+func TypeFields(t reflect.Type) []Field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]Field)
+	}
+	fields := make([]Field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(sf.Tag.Get("bencode"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, Field{
+			Name:      name,
+			Index:     i,
+			OmitEmpty: slices.Contains(strings.Split(options, ","), "omitempty"),
+		})
+	}
+	slices.SortFunc(fields, func(a, b Field) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	fieldCache.Store(t, fields)
+	return fields
+}
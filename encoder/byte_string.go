@@ -0,0 +1,11 @@
+package encoder
+
+import "unicode/utf8"
+
+// ByteString is a bencoded byte string that may hold arbitrary binary data, such as piece hashes
+type ByteString []byte
+
+// IsText reports whether the byte string is valid UTF-8 and can be shown as text
+func (b ByteString) IsText() bool {
+	return utf8.Valid(b)
+}
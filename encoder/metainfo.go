@@ -0,0 +1,41 @@
+package encoder
+
+// MetaInfo mirrors the bencoded dictionary of a .torrent file
+// Info is kept as raw bytes since the info hash must be computed over its exact encoding
+type MetaInfo struct {
+	Announce     string            `bencode:"announce,omitempty"`
+	AnnounceList [][]string        `bencode:"announce-list,omitempty"`
+	Comment      string            `bencode:"comment,omitempty"`
+	CreatedBy    string            `bencode:"created by,omitempty"`
+	CreationDate int64             `bencode:"creation date,omitempty"`
+	Info         RawMessage        `bencode:"info"`
+	PieceLayers  map[string]string `bencode:"piece layers,omitempty"` // BEP 52, pieces root -> concatenated piece hashes
+	URLList      RawMessage        `bencode:"url-list,omitempty"`     // BEP 19 web seeds, either a single URL or a list of URLs
+}
+
+// InfoDict mirrors the bencoded info dictionary of a .torrent file
+// v1 torrents have pieces and either length or files, v2 torrents (BEP 52) have a meta version and a file tree,
+// hybrid torrents have both
+type InfoDict struct {
+	FileTree    RawMessage `bencode:"file tree,omitempty"`
+	Files       []InfoFile `bencode:"files,omitempty"`
+	Length      int        `bencode:"length,omitempty"`
+	MetaVersion int        `bencode:"meta version,omitempty"`
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Pieces      string     `bencode:"pieces,omitempty"`
+	Private     int        `bencode:"private,omitempty"`
+}
+
+// InfoFile is an entry of the files list of a multi-file info dictionary
+type InfoFile struct {
+	Attr   string   `bencode:"attr,omitempty"` // BEP 47 attributes, "p" marks a padding file
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+// FileTreeEntry describes a file in a v2 file tree, it is stored under the empty key of the file's dictionary
+type FileTreeEntry struct {
+	Length     int    `bencode:"length"`
+	PiecesRoot string `bencode:"pieces root,omitempty"` // Merkle root of the file, absent for empty files
+}
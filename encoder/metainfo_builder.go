@@ -0,0 +1,188 @@
+package encoder
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+const (
+	MIN_PIECE_LENGTH    = 16 * 1024        // One block, the smallest piece we create
+	MAX_PIECE_LENGTH    = 16 * 1024 * 1024 // Largest piece length picked automatically
+	TARGET_PIECE_NUMBER = 1500             // Number of pieces the automatic piece length aims for
+)
+
+// MetaInfoBuilder creates the metainfo of a .torrent file from a file or a directory on disk
+type MetaInfoBuilder struct {
+	Announce     string     // Main tracker URL, defaults to the first tracker of AnnounceList
+	AnnounceList [][]string // Tiers of tracker URLs (BEP 12)
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // Left out of the torrent when zero
+	Private      bool      // Restrict peers to the torrent's trackers (BEP 27)
+	Name         string    // Defaults to the base name of the path
+	PieceLength  int       // A power of two of at least MIN_PIECE_LENGTH, chosen from the total length when zero
+}
+
+// Build hashes the file or directory at path and returns the bencoded .torrent file
+func (b *MetaInfoBuilder) Build(path string) ([]byte, error) {
+	info, err := b.BuildInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	rawInfo, err := Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	meta := MetaInfo{
+		Announce:     b.Announce,
+		AnnounceList: b.AnnounceList,
+		Comment:      b.Comment,
+		CreatedBy:    b.CreatedBy,
+		Info:         rawInfo,
+	}
+	if meta.Announce == "" && len(b.AnnounceList) > 0 && len(b.AnnounceList[0]) > 0 {
+		meta.Announce = b.AnnounceList[0][0]
+	}
+	if !b.CreationDate.IsZero() {
+		meta.CreationDate = b.CreationDate.Unix()
+	}
+	return Marshal(meta)
+}
+
+// BuildInfo hashes the file or directory at path into an info dictionary
+// Directories become multi-file torrents, their files are sorted by path and symbolic links are skipped
+func (b *MetaInfoBuilder) BuildInfo(path string) (*InfoDict, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	name := b.Name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(path))
+	}
+	if _, err := utils.SafeJoin("", name); err != nil {
+		return nil, fmt.Errorf("invalid torrent name: %v", err)
+	}
+
+	// The files to hash, in the order their data is laid out in the pieces
+	var paths []string
+	info := &InfoDict{Name: name}
+	if stat.IsDir() {
+		paths, info.Files, err = listFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Files) == 0 {
+			return nil, fmt.Errorf("no files to add in %s", path)
+		}
+	} else {
+		paths = []string{path}
+		info.Length = int(stat.Size())
+	}
+	total := info.Length
+	for _, f := range info.Files {
+		total += f.Length
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("cannot create a torrent of empty files")
+	}
+
+	info.PieceLength = b.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = AutoPieceLength(total)
+	}
+	if info.PieceLength < MIN_PIECE_LENGTH || info.PieceLength&(info.PieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d must be a power of two of at least %d", info.PieceLength, MIN_PIECE_LENGTH)
+	}
+	info.Pieces, err = hashPieces(paths, info.PieceLength)
+	if err != nil {
+		return nil, err
+	}
+	if b.Private {
+		info.Private = 1
+	}
+	return info, nil
+}
+
+// AutoPieceLength picks a power of two piece length that gives about TARGET_PIECE_NUMBER pieces
+func AutoPieceLength(totalLength int) int {
+	pieceLength := MIN_PIECE_LENGTH
+	for pieceLength < MAX_PIECE_LENGTH && totalLength/pieceLength > TARGET_PIECE_NUMBER {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// List the regular files under root, sorted by path
+func listFiles(root string) ([]string, []InfoFile, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.Sort(paths)
+	files := make([]InfoFile, 0, len(paths))
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, InfoFile{
+			Length: int(stat.Size()),
+			Path:   strings.Split(filepath.ToSlash(relative), "/"),
+		})
+	}
+	return paths, files, nil
+}
+
+// Hash the concatenation of the files piece by piece, keeping a single piece in memory and one file open at a time
+func hashPieces(paths []string, pieceLength int) (string, error) {
+	var pieces strings.Builder
+	buff := make([]byte, 0, pieceLength)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		for {
+			n, err := f.Read(buff[len(buff):cap(buff)])
+			buff = buff[:len(buff)+n]
+			if len(buff) == cap(buff) {
+				pieces.WriteString(utils.SHA1Hash(buff))
+				buff = buff[:0]
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return "", fmt.Errorf("error while hashing %s: %v", path, err)
+			}
+		}
+		f.Close()
+	}
+	if len(buff) > 0 {
+		pieces.WriteString(utils.SHA1Hash(buff))
+	}
+	return pieces.String(), nil
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
+const HTTP_TIMEOUT = 30 * time.Second // Time a request has to complete, so that a hung tracker does not block the next one
+
 type Client struct {
 	RemoteURL string
 }
@@ -32,13 +35,16 @@ func (c *Client) CreateRequest(method, urlpart string, bodyData *bytes.Buffer) (
 
 // Make a request and decode the response into the target interface
 func (c *Client) MakeRequest(req *http.Request) ([]byte, error) {
-	client := &http.Client{}
+	client := &http.Client{Timeout: HTTP_TIMEOUT}
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Error while making request: ", err)
 		return []byte{}, err
 	}
 	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return []byte{}, err
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Println("Error while reading the response: ", err)
@@ -49,3 +55,25 @@ func (c *Client) MakeRequest(req *http.Request) ([]byte, error) {
 
 	return body, nil
 }
+
+// Make a request and return the response body as a stream, the caller must close it
+func (c *Client) StreamRequest(req *http.Request) (io.ReadCloser, error) {
+	client := &http.Client{Timeout: HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Error pages are not bencoded, report the status instead of failing to decode them
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the server answered with status %s", resp.Status)
+	}
+	return nil
+}
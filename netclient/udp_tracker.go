@@ -0,0 +1,315 @@
+package netclient
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	UDP_PROTOCOL_ID            = 0x41727101980    // Magic constant of connect requests (BEP 15)
+	UDP_TRACKER_TIMEOUT        = 15 * time.Second // Timeout of the first attempt, doubled after every retransmission
+	UDP_TRACKER_MAX_RETRIES    = 8                // Retransmissions before giving up, the last one waits 15 * 2^8 seconds
+	UDP_CONNECTION_ID_LIFETIME = time.Minute      // How long a connection ID can be used for
+	UDP_MAX_SCRAPE_HASHES      = 74               // Info hashes that fit in one scrape request
+	UDP_MAX_PACKET_LENGTH      = 65507
+)
+
+const ( // UDP tracker actions
+	UDP_ACTION_CONNECT = iota
+	UDP_ACTION_ANNOUNCE
+	UDP_ACTION_SCRAPE
+	UDP_ACTION_ERROR
+)
+
+// AnnounceEvent is the event sent with an announce, numbered as in UDP announces
+type AnnounceEvent int32
+
+const (
+	EVENT_NONE AnnounceEvent = iota
+	EVENT_COMPLETED
+	EVENT_STARTED
+	EVENT_STOPPED
+)
+
+// String returns the value of the event parameter of HTTP announces, empty for EVENT_NONE
+func (e AnnounceEvent) String() string {
+	switch e {
+	case EVENT_COMPLETED:
+		return "completed"
+	case EVENT_STARTED:
+		return "started"
+	case EVENT_STOPPED:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// AnnounceRequest holds the parameters of an announce, InfoHash and PeerID are raw 20-byte strings
+type AnnounceRequest struct {
+	InfoHash   string
+	PeerID     string
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      AnnounceEvent
+	Key        uint32
+	NumWant    int32 // -1 lets the tracker decide
+}
+
+// UDPAnnounceResponse is the answer of a UDP tracker to an announce
+type UDPAnnounceResponse struct {
+	Interval int
+	Leechers int
+	Seeders  int
+	Peers    []netip.AddrPort
+}
+
+// ScrapeStats are the statistics of a torrent's swarm returned by a scrape
+type ScrapeStats struct {
+	Seeders   int
+	Completed int
+	Leechers  int
+}
+
+// UDPTrackerError is an error message sent by a UDP tracker
+type UDPTrackerError struct {
+	Message string
+}
+
+func (e *UDPTrackerError) Error() string {
+	return "tracker returned an error: " + e.Message
+}
+
+// UDPTracker talks to a tracker with the UDP tracker protocol (BEP 15)
+type UDPTracker struct {
+	Host       string        // host:port of the tracker
+	Timeout    time.Duration // Timeout of the first attempt, UDP_TRACKER_TIMEOUT when zero
+	MaxRetries int           // UDP_TRACKER_MAX_RETRIES when zero
+	MaxTimeout time.Duration // Caps the timeout of every attempt when not zero
+}
+
+func NewUDPTracker(announceURL string) (*UDPTracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid UDP tracker URL %s", announceURL)
+	}
+	return &UDPTracker{Host: u.Host}, nil
+}
+
+// Connection IDs are shared by every UDPTracker of the same host for UDP_CONNECTION_ID_LIFETIME
+var connectionIDs = struct {
+	sync.Mutex
+	ids map[string]connectionID
+}{ids: map[string]connectionID{}}
+
+type connectionID struct {
+	id       uint64
+	received time.Time
+}
+
+// Announce sends an announce and returns the peers of the swarm
+func (t *UDPTracker) Announce(req *AnnounceRequest) (*UDPAnnounceResponse, error) {
+	if len(req.InfoHash) != 20 || len(req.PeerID) != 20 {
+		return nil, fmt.Errorf("info hash and peer ID must be 20 bytes long")
+	}
+	conn, err := net.Dial("udp", t.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	response, err := t.exchange(conn, UDP_ACTION_ANNOUNCE, func(connID uint64, transactionID uint32) []byte {
+		packet := make([]byte, 98)
+		binary.BigEndian.PutUint64(packet[0:8], connID)
+		binary.BigEndian.PutUint32(packet[8:12], UDP_ACTION_ANNOUNCE)
+		binary.BigEndian.PutUint32(packet[12:16], transactionID)
+		copy(packet[16:36], req.InfoHash)
+		copy(packet[36:56], req.PeerID)
+		binary.BigEndian.PutUint64(packet[56:64], uint64(req.Downloaded))
+		binary.BigEndian.PutUint64(packet[64:72], uint64(req.Left))
+		binary.BigEndian.PutUint64(packet[72:80], uint64(req.Uploaded))
+		binary.BigEndian.PutUint32(packet[80:84], uint32(req.Event))
+		// The IP address at 84:88 is left to 0 for the tracker to use the packet's source address
+		binary.BigEndian.PutUint32(packet[88:92], req.Key)
+		binary.BigEndian.PutUint32(packet[92:96], uint32(req.NumWant))
+		binary.BigEndian.PutUint16(packet[96:98], req.Port)
+		return packet
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 20 {
+		return nil, fmt.Errorf("announce response of %d bytes is too short", len(response))
+	}
+	// Peers are IPv6 when the tracker is reached over IPv6
+	peerLength := 6
+	if remote, ok := conn.RemoteAddr().(*net.UDPAddr); ok && remote.IP.To4() == nil {
+		peerLength = 18
+	}
+	result := &UDPAnnounceResponse{
+		Interval: int(binary.BigEndian.Uint32(response[8:12])),
+		Leechers: int(binary.BigEndian.Uint32(response[12:16])),
+		Seeders:  int(binary.BigEndian.Uint32(response[16:20])),
+	}
+	for i := 20; i+peerLength <= len(response); i += peerLength {
+		addr, _ := netip.AddrFromSlice(response[i : i+peerLength-2])
+		port := binary.BigEndian.Uint16(response[i+peerLength-2 : i+peerLength])
+		result.Peers = append(result.Peers, netip.AddrPortFrom(addr, port))
+	}
+	return result, nil
+}
+
+// Scrape returns the statistics of the swarms of up to UDP_MAX_SCRAPE_HASHES info hashes, in the same order
+func (t *UDPTracker) Scrape(infoHashes []string) ([]ScrapeStats, error) {
+	if len(infoHashes) == 0 || len(infoHashes) > UDP_MAX_SCRAPE_HASHES {
+		return nil, fmt.Errorf("a scrape needs between 1 and %d info hashes, got %d", UDP_MAX_SCRAPE_HASHES, len(infoHashes))
+	}
+	conn, err := net.Dial("udp", t.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	response, err := t.exchange(conn, UDP_ACTION_SCRAPE, func(connID uint64, transactionID uint32) []byte {
+		packet := make([]byte, 16, 16+20*len(infoHashes))
+		binary.BigEndian.PutUint64(packet[0:8], connID)
+		binary.BigEndian.PutUint32(packet[8:12], UDP_ACTION_SCRAPE)
+		binary.BigEndian.PutUint32(packet[12:16], transactionID)
+		for _, h := range infoHashes {
+			packet = append(packet, h...)
+		}
+		return packet
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 8+12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response of %d bytes is too short for %d info hashes", len(response), len(infoHashes))
+	}
+	stats := make([]ScrapeStats, len(infoHashes))
+	for i := range stats {
+		entry := response[8+12*i:]
+		stats[i] = ScrapeStats{
+			Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+	return stats, nil
+}
+
+// Send a request built with a valid connection ID and wait for the response of the same transaction
+// Requests are retransmitted after 15 * 2^n seconds, and a new connection ID is obtained when the cached one expires
+// or after a failed request, as the tracker may have restarted and no longer accept it
+func (t *UDPTracker) exchange(conn net.Conn, action uint32, build func(connID uint64, transactionID uint32) []byte) ([]byte, error) {
+	timeout, maxRetries := t.Timeout, t.MaxRetries
+	if timeout == 0 {
+		timeout = UDP_TRACKER_TIMEOUT
+	}
+	if maxRetries == 0 {
+		maxRetries = UDP_TRACKER_MAX_RETRIES
+	}
+	buff := make([]byte, UDP_MAX_PACKET_LENGTH)
+	for n := 0; n <= maxRetries; n++ {
+		attemptTimeout := timeout << n
+		if t.MaxTimeout > 0 {
+			attemptTimeout = min(attemptTimeout, t.MaxTimeout)
+		}
+		connID, err := t.connectionID(conn, attemptTimeout, buff)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response, err := roundTrip(conn, action, build, connID, attemptTimeout, buff)
+		if err != nil {
+			t.forgetConnectionID(connID)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		return response, err
+	}
+	return nil, fmt.Errorf("tracker %s did not answer after %d retransmissions", t.Host, maxRetries)
+}
+
+// Return the cached connection ID of the tracker, or connect to get a new one
+// buff must hold a whole datagram, as an error response carries a message of any length
+func (t *UDPTracker) connectionID(conn net.Conn, timeout time.Duration, buff []byte) (uint64, error) {
+	connectionIDs.Lock()
+	cached, ok := connectionIDs.ids[t.Host]
+	connectionIDs.Unlock()
+	if ok && time.Since(cached.received) < UDP_CONNECTION_ID_LIFETIME {
+		return cached.id, nil
+	}
+	response, err := roundTrip(conn, UDP_ACTION_CONNECT, func(_ uint64, transactionID uint32) []byte {
+		packet := make([]byte, 16)
+		binary.BigEndian.PutUint64(packet[0:8], UDP_PROTOCOL_ID)
+		binary.BigEndian.PutUint32(packet[8:12], UDP_ACTION_CONNECT)
+		binary.BigEndian.PutUint32(packet[12:16], transactionID)
+		return packet
+	}, 0, timeout, buff)
+	if err != nil {
+		return 0, err
+	}
+	if len(response) < 16 {
+		return 0, fmt.Errorf("connect response of %d bytes is too short", len(response))
+	}
+	id := binary.BigEndian.Uint64(response[8:16])
+	connectionIDs.Lock()
+	connectionIDs.ids[t.Host] = connectionID{id: id, received: time.Now()}
+	connectionIDs.Unlock()
+	return id, nil
+}
+
+// Drop the cached connection ID of the tracker, unless another request already replaced it
+func (t *UDPTracker) forgetConnectionID(id uint64) {
+	connectionIDs.Lock()
+	defer connectionIDs.Unlock()
+	if cached, ok := connectionIDs.ids[t.Host]; ok && cached.id == id {
+		delete(connectionIDs.ids, t.Host)
+	}
+}
+
+// Send one request with a new transaction ID and read packets until the matching response or the timeout
+func roundTrip(conn net.Conn, action uint32, build func(connID uint64, transactionID uint32) []byte, connID uint64, timeout time.Duration, buff []byte) ([]byte, error) {
+	var txid [4]byte
+	if _, err := rand.Read(txid[:]); err != nil {
+		return nil, err
+	}
+	transactionID := binary.BigEndian.Uint32(txid[:])
+	if _, err := conn.Write(build(connID, transactionID)); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			return nil, err
+		}
+		response := buff[:n]
+		if n < 8 || binary.BigEndian.Uint32(response[4:8]) != transactionID {
+			continue // Late answer to a previous attempt, or garbage
+		}
+		switch binary.BigEndian.Uint32(response[0:4]) {
+		case action:
+			return append([]byte{}, response...), nil
+		case UDP_ACTION_ERROR:
+			return nil, &UDPTrackerError{Message: string(response[8:])}
+		default:
+			return nil, fmt.Errorf("unexpected action %d in the tracker's response", binary.BigEndian.Uint32(response[0:4]))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+type marshalFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type marshalInfo struct {
+	Name        string            `bencode:"name"`
+	PieceLength int               `bencode:"piece length"`
+	Pieces      []byte            `bencode:"pieces"`
+	Private     bool              `bencode:"private,omitempty"`
+	Files       []marshalFile     `bencode:"files,omitempty"`
+	Extra       map[string]string `bencode:"extra,omitempty"`
+	Ignored     string            `bencode:"-"`
+}
+
+type marshalTorrent struct {
+	Announce string             `bencode:"announce"`
+	Comment  *string            `bencode:"comment"`
+	Info     marshalInfo        `bencode:"info"`
+	Raw      encoder.RawMessage `bencode:"raw,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	t.Run("Marshal struct with tags", func(t *testing.T) {
+		torrent := marshalTorrent{
+			Announce: "http://tracker/announce",
+			Info: marshalInfo{
+				Name:        "dir",
+				PieceLength: 16,
+				Pieces:      []byte{0x00, 0xff},
+				Files:       []marshalFile{{Length: 5, Path: []string{"a", "b"}}},
+				Ignored:     "not encoded",
+			},
+			Raw: encoder.RawMessage("d1:xi1ee"),
+		}
+		encoded, err := encoder.Marshal(torrent)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		expected := "d8:announce23:http://tracker/announce4:infod5:filesld6:lengthi5e4:pathl1:a1:beee4:name3:dir12:piece lengthi16e6:pieces2:\x00\xffe3:rawd1:xi1eee"
+		if string(encoded) != expected {
+			t.Errorf("Expected %q, got %q", expected, encoded)
+		}
+	})
+	t.Run("Marshal unsupported type", func(t *testing.T) {
+		_, err := encoder.Marshal(map[string]interface{}{"float": 1.5})
+		var unsupported *encoder.UnsupportedTypeError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("Expected an unsupported type error, got %v", err)
+		}
+	})
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Run("Unmarshal round trip", func(t *testing.T) {
+		comment := "hello"
+		torrent := marshalTorrent{
+			Announce: "http://tracker/announce",
+			Comment:  &comment,
+			Info: marshalInfo{
+				Name:        "dir",
+				PieceLength: 16,
+				Pieces:      []byte{0x00, 0xff},
+				Private:     true,
+				Files:       []marshalFile{{Length: 5, Path: []string{"a", "b"}}},
+				Extra:       map[string]string{"k": "v"},
+			},
+			Raw: encoder.RawMessage("l4:spame"),
+		}
+		encoded, err := encoder.Marshal(torrent)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		var decoded marshalTorrent
+		if err := decoder.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if !reflect.DeepEqual(torrent, decoded) {
+			t.Errorf("Expected %+v, got %+v", torrent, decoded)
+		}
+	})
+	t.Run("Unmarshal skips unknown keys", func(t *testing.T) {
+		var decoded marshalFile
+		err := decoder.Unmarshal([]byte("d5:extrald1:ai1eee6:lengthi7e4:pathl1:xee"), &decoded)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if decoded.Length != 7 || !reflect.DeepEqual(decoded.Path, []string{"x"}) {
+			t.Errorf("Unexpected value %+v", decoded)
+		}
+	})
+	t.Run("Unmarshal type mismatch", func(t *testing.T) {
+		var decoded marshalFile
+		err := decoder.Unmarshal([]byte("d6:length3:abce"), &decoded)
+		var typeErr *decoder.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			t.Fatalf("Expected a type error, got %v", err)
+		}
+		if typeErr.Offset != 9 {
+			t.Errorf("Expected offset to be 9, got %d", typeErr.Offset)
+		}
+	})
+}
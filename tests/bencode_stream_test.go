@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+var BencodeDecoderErrorTests = []struct {
+	description    string
+	input          string
+	expectedOffset int64
+}{
+	{
+		description:    "Truncated string",
+		input:          "10:hello",
+		expectedOffset: 0,
+	},
+	{
+		description:    "Missing string length",
+		input:          "l:helloe",
+		expectedOffset: 1,
+	},
+	{
+		description:    "Invalid character in integer",
+		input:          "li1x2ee",
+		expectedOffset: 3,
+	},
+	{
+		description:    "Empty integer",
+		input:          "d3:fooiee",
+		expectedOffset: 6,
+	},
+	{
+		description:    "Non string dictionary key",
+		input:          "d3:fooi1ei2ei3ee",
+		expectedOffset: 9,
+	},
+	{
+		description:    "Unterminated list",
+		input:          "l3:one",
+		expectedOffset: 6,
+	},
+	{
+		description:    "Unsupported type in list",
+		input:          "l3:onexe",
+		expectedOffset: 6,
+	},
+}
+
+func TestBencodeDecoderErrors(t *testing.T) {
+	for _, test := range BencodeDecoderErrorTests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := decoder.NewBencodeDecoder(strings.NewReader(test.input)).Decode()
+			var syntaxErr *decoder.SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Expected a syntax error, got %v", err)
+			}
+			if syntaxErr.Offset != test.expectedOffset {
+				t.Errorf("Expected offset to be %d, got %d (%v)", test.expectedOffset, syntaxErr.Offset, err)
+			}
+		})
+	}
+}
+
+func TestBencodeDecoderStream(t *testing.T) {
+	t.Run("Decode consecutive values", func(t *testing.T) {
+		d := decoder.NewBencodeDecoder(strings.NewReader("i1e4:spaml1:ae"))
+		expected := []interface{}{1, "spam", []interface{}{"a"}}
+		for _, want := range expected {
+			value, err := d.Decode()
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !reflect.DeepEqual(value, want) {
+				t.Errorf("Expected value to be %v, got %v", want, value)
+			}
+		}
+		if _, err := d.Decode(); err != io.EOF {
+			t.Errorf("Expected io.EOF at the end of the stream, got %v", err)
+		}
+		if d.Offset() != 14 {
+			t.Errorf("Expected offset to be 14, got %d", d.Offset())
+		}
+	})
+}
+
+var BencodeDecoderStrictTests = []struct {
+	description    string
+	input          string
+	expectedError  error
+	expectedOffset int64
+}{
+	{
+		description:    "Leading zero in integer",
+		input:          "i03e",
+		expectedError:  decoder.ErrLeadingZero,
+		expectedOffset: 0,
+	},
+	{
+		description:    "Negative zero",
+		input:          "li1ei-0ee",
+		expectedError:  decoder.ErrNegativeZero,
+		expectedOffset: 4,
+	},
+	{
+		description:    "Leading zero in string length",
+		input:          "03:abc",
+		expectedError:  decoder.ErrLeadingZero,
+		expectedOffset: 0,
+	},
+	{
+		description:    "Unsorted dictionary keys",
+		input:          "d3:zooi1e3:bari2ee",
+		expectedError:  decoder.ErrUnsortedKeys,
+		expectedOffset: 9,
+	},
+	{
+		description:    "Duplicate dictionary keys",
+		input:          "d3:bari1e3:bari2ee",
+		expectedError:  decoder.ErrDuplicateKey,
+		expectedOffset: 9,
+	},
+}
+
+func TestBencodeDecoderStrict(t *testing.T) {
+	for _, test := range BencodeDecoderStrictTests {
+		t.Run(test.description, func(t *testing.T) {
+			// The lenient decoder accepts the input
+			if _, err := decoder.NewBencodeDecoder(strings.NewReader(test.input)).Decode(); err != nil {
+				t.Fatalf("Expected lenient decoding to succeed, got %v", err)
+			}
+			d := decoder.NewBencodeDecoder(strings.NewReader(test.input))
+			d.SetStrict(true)
+			_, err := d.Decode()
+			if !errors.Is(err, test.expectedError) {
+				t.Fatalf("Expected error to be %v, got %v", test.expectedError, err)
+			}
+			var syntaxErr *decoder.SyntaxError
+			if !errors.As(err, &syntaxErr) || syntaxErr.Offset != test.expectedOffset {
+				t.Errorf("Expected offset to be %d, got %v", test.expectedOffset, err)
+			}
+		})
+	}
+	t.Run("Canonical input", func(t *testing.T) {
+		var v map[string]interface{}
+		if err := decoder.UnmarshalStrict([]byte("d3:bari-5e3:fooi0e3:zoo0:e"), &v); err != nil {
+			t.Errorf("Expected error to be nil, got %v", err)
+		}
+	})
+}
+
+func TestBencodeDecoderTypes(t *testing.T) {
+	t.Run("Decode byte strings and 64-bit integers", func(t *testing.T) {
+		d := decoder.NewBencodeDecoder(strings.NewReader("l2:\xff\x00i-9223372036854775808ei18446744073709551616ee"))
+		d.UseByteStrings()
+		d.UseInt64()
+		value, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		list := value.([]interface{})
+		if !reflect.DeepEqual(list[0], decoder.ByteString{0xff, 0x00}) {
+			t.Errorf("Expected a byte string, got %#v", list[0])
+		}
+		if list[1] != int64(math.MinInt64) {
+			t.Errorf("Expected %d, got %#v", int64(math.MinInt64), list[1])
+		}
+		if n, ok := list[2].(*big.Int); !ok || n.String() != "18446744073709551616" {
+			t.Errorf("Expected a big integer, got %#v", list[2])
+		}
+		encoded, err := encoder.Marshal(list)
+		if err != nil || string(encoded) != "l2:\xff\x00i-9223372036854775808ei18446744073709551616ee" {
+			t.Errorf("Expected the values to round trip, got %q (%v)", encoded, err)
+		}
+	})
+}
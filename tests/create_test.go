@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+func TestMetaInfoBuilder(t *testing.T) {
+	t.Run("Build multi-file torrent", func(t *testing.T) {
+		root := filepath.Join(t.TempDir(), "build")
+		data := map[string][]byte{
+			"a.bin":     make([]byte, 20000),
+			"sub/b.txt": []byte("hello"),
+		}
+		for name, content := range data {
+			path := filepath.Join(root, name)
+			os.MkdirAll(filepath.Dir(path), 0o755)
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		builder := &encoder.MetaInfoBuilder{
+			AnnounceList: [][]string{{"http://a/announce"}, {"udp://b:6969"}},
+			Comment:      "artifacts",
+			CreatedBy:    "tests",
+			CreationDate: time.Unix(1700000000, 0),
+			Private:      true,
+		}
+		content, err := builder.Build(root)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		torrent, _, err := decoder.DecodeTorrentFile(string(content))
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if torrent.Announce != "http://a/announce" || torrent.Name != "build" || !torrent.Private || torrent.Length != 20005 {
+			t.Errorf("Unexpected torrent %+v", torrent)
+		}
+		if torrent.PieceLength != encoder.MIN_PIECE_LENGTH || len(torrent.PieceHashes) != 2 {
+			t.Errorf("Expected 2 pieces of %d bytes, got %d of %d", encoder.MIN_PIECE_LENGTH, len(torrent.PieceHashes), torrent.PieceLength)
+		}
+		// The second piece spans the end of a.bin and the whole of sub/b.txt
+		second := append(make([]byte, 20000-encoder.MIN_PIECE_LENGTH), []byte("hello")...)
+		if torrent.PieceHashes[1] != fmt.Sprintf("%x", utils.SHA1Hash(second)) {
+			t.Errorf("Unexpected hash for the second piece %s", torrent.PieceHashes[1])
+		}
+		if !reflect.DeepEqual(torrent.Files[1].Path, []string{"sub", "b.txt"}) {
+			t.Errorf("Unexpected file path %v", torrent.Files[1].Path)
+		}
+		// The output is canonical
+		var meta decoder.MetaInfo
+		if err := decoder.UnmarshalStrict(content, &meta); err != nil {
+			t.Errorf("Expected a canonical encoding, got %v", err)
+		}
+		if meta.Comment != "artifacts" || meta.CreationDate != 1700000000 {
+			t.Errorf("Unexpected metainfo %+v", meta)
+		}
+	})
+	t.Run("Reject piece length that is not a power of two", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(path, []byte("data"), 0o644)
+		builder := &encoder.MetaInfoBuilder{Announce: "http://a", PieceLength: 20000}
+		if _, err := builder.Build(path); err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+	t.Run("Create a torrent without trackers", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file")
+		os.WriteFile(path, []byte("data"), 0o644)
+		torrent, err := command.Create(path, filepath.Join(dir, "file.torrent"), &encoder.MetaInfoBuilder{})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if len(torrent.Trackers()) != 0 || torrent.Length != 4 {
+			t.Errorf("Unexpected torrent %+v", torrent)
+		}
+	})
+}
@@ -3,9 +3,11 @@ package tests
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
 	"github.com/codecrafters-io/bittorrent-starter-go/utils"
 )
 
@@ -113,3 +115,82 @@ func TestDecodeTorrentFile(t *testing.T) {
 		}
 	})
 }
+
+func TestTorrentInfoHash(t *testing.T) {
+	t.Run("Info hash of a non-canonical info dictionary", func(t *testing.T) {
+		// Keys of the info dictionary are not sorted, re-encoding it would change the hash
+		info := "d6:pieces20:aaaaaaaaaaaaaaaaaaaa4:name4:file12:piece lengthi16e6:lengthi10ee"
+		content := "d8:announce15:http://tracker/4:info" + info + "e"
+		torrent, _, err := decoder.DecodeTorrentFile(content)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if torrent.InfoHash != utils.SHA1Hash([]byte(info)) {
+			t.Errorf("Expected info hash to be %x, got %x", utils.SHA1Hash([]byte(info)), torrent.InfoHash)
+		}
+		start, end, err := decoder.ValueSpan([]byte(content), "info")
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if content[start:end] != info {
+			t.Errorf("Expected span to be %q, got %q", info, content[start:end])
+		}
+		start, end, err = decoder.ValueSpan([]byte(content), "info", "piece length")
+		if err != nil || content[start:end] != "i16e" {
+			t.Errorf("Expected nested span to be %q, got %q (%v)", "i16e", content[start:end], err)
+		}
+	})
+}
+
+func makeTorrent(t *testing.T, info decoder.InfoDict) string {
+	rawInfo, err := encoder.Marshal(info)
+	if err != nil {
+		t.Fatalf("unable to encode info dictionary: %v", err)
+	}
+	content, err := encoder.Marshal(decoder.MetaInfo{Announce: "http://tracker/announce", Info: rawInfo})
+	if err != nil {
+		t.Fatalf("unable to encode torrent: %v", err)
+	}
+	return string(content)
+}
+
+func TestDecodeMultiFileTorrent(t *testing.T) {
+	t.Run("Decode multi-file torrent", func(t *testing.T) {
+		content := makeTorrent(t, decoder.InfoDict{
+			Name:        "dir",
+			PieceLength: 16,
+			Pieces:      strings.Repeat("a", 40),
+			Files: []decoder.InfoFile{
+				{Length: 10, Path: []string{"a.txt"}},
+				{Length: 12, Path: []string{"sub", "b.txt"}},
+			},
+		})
+		torrent, _, err := decoder.DecodeTorrentFile(content)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		expected := []decoder.FileEntry{
+			{Path: []string{"a.txt"}, Length: 10, Offset: 0},
+			{Path: []string{"sub", "b.txt"}, Length: 12, Offset: 10},
+		}
+		if !torrent.MultiFile || torrent.Name != "dir" || torrent.Length != 22 {
+			t.Errorf("Unexpected torrent %+v", torrent)
+		}
+		if !reflect.DeepEqual(torrent.Files, expected) {
+			t.Errorf("Expected files to be %+v, got %+v", expected, torrent.Files)
+		}
+	})
+	for _, path := range [][]string{{"..", "etc", "passwd"}, {"/etc/passwd"}, {"a/../../b"}, {""}} {
+		t.Run("Reject unsafe path "+strings.Join(path, "/"), func(t *testing.T) {
+			content := makeTorrent(t, decoder.InfoDict{
+				Name:        "dir",
+				PieceLength: 16,
+				Pieces:      strings.Repeat("a", 20),
+				Files:       []decoder.InfoFile{{Length: 10, Path: path}},
+			})
+			if _, _, err := decoder.DecodeTorrentFile(content); err == nil {
+				t.Errorf("Expected an error for path %q", path)
+			}
+		})
+	}
+}
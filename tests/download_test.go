@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+func TestDownload(t *testing.T) {
+	data := make([]byte, 10*command.BLOCK_LENGTH+1000)
+	rand.New(rand.NewSource(2)).Read(data)
+	torrent := makePieceTorrent(t, data, command.BLOCK_LENGTH)
+	var first, second peerStats
+	// A peer that refuses connections, its worker moves on to the next peer
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	peers := []string{servePieces(t, data, command.BLOCK_LENGTH, &first), closed.Addr().String(), servePieces(t, data, command.BLOCK_LENGTH, &second)}
+
+	output := filepath.Join(t.TempDir(), "data")
+	if err := command.Download(torrent, peers, output, nil); err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	written, _ := os.ReadFile(output)
+	if !bytes.Equal(written, data) {
+		t.Errorf("The downloaded data does not match")
+	}
+	// Every peer serves all of its pieces over a single connection, closed once the download is done
+	if first.accepted.Load() > 1 || second.accepted.Load() > 1 || first.accepted.Load()+second.accepted.Load() == 0 {
+		t.Errorf("Expected at most one connection per peer, got %d and %d", first.accepted.Load(), second.accepted.Load())
+	}
+	deadline := time.Now().Add(time.Second)
+	for first.open.Load()+second.open.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if open := first.open.Load() + second.open.Load(); open != 0 {
+		t.Errorf("Expected every connection to be closed, %d still open", open)
+	}
+}
+
+func TestDownloadReannounce(t *testing.T) {
+	data := make([]byte, 4*command.BLOCK_LENGTH)
+	rand.New(rand.NewSource(4)).Read(data)
+	torrent := makePieceTorrent(t, data, command.BLOCK_LENGTH)
+	var stats peerStats
+	seeder, _ := netip.ParseAddrPort(servePieces(t, data, command.BLOCK_LENGTH, &stats))
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	refused, _ := netip.ParseAddrPort(closed.Addr().String())
+	// The tracker only knows the seeder from the first re-announce on
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := seeder
+		if r.URL.Query().Get("event") == "started" {
+			peer = refused
+		}
+		ip := peer.Addr().As4()
+		compact := binary.BigEndian.AppendUint16(ip[:], peer.Port())
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800, Peers: decoder.RawMessage(fmt.Sprintf("%d:%s", len(compact), compact))})
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), torrent.InfoHash, len(data))
+	peers, err := session.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Stop()
+	output := filepath.Join(t.TempDir(), "data")
+	if err := command.Download(torrent, command.PeerAddrs(peers), output, session); err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	if written, _ := os.ReadFile(output); !bytes.Equal(written, data) {
+		t.Errorf("The downloaded data does not match")
+	}
+	if stats.accepted.Load() != 1 {
+		t.Errorf("Expected the seeder from the re-announce to be used once, got %d connections", stats.accepted.Load())
+	}
+}
+
+func TestDownloadRetriesFailedPeer(t *testing.T) {
+	backoff := command.PeerRetryBackoff
+	command.PeerRetryBackoff = 50 * time.Millisecond
+	defer func() { command.PeerRetryBackoff = backoff }()
+	data := make([]byte, 4*command.BLOCK_LENGTH)
+	rand.New(rand.NewSource(6)).Read(data)
+	torrent := makePieceTorrent(t, data, command.BLOCK_LENGTH)
+	var stats peerStats
+	var dropped atomic.Bool
+	seed := seedPieces(data, command.BLOCK_LENGTH, &stats)
+	// The only peer drops the first connection, it is tried again when a re-announce returns it
+	seeder, _ := netip.ParseAddrPort(fakePeer(t, func(wire *command.PeerWire) {
+		if dropped.CompareAndSwap(false, true) {
+			return
+		}
+		seed(wire)
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := seeder.Addr().As4()
+		compact := binary.BigEndian.AppendUint16(ip[:], seeder.Port())
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800, Peers: decoder.RawMessage(fmt.Sprintf("%d:%s", len(compact), compact))})
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), torrent.InfoHash, len(data))
+	peers, err := session.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Stop()
+	output := filepath.Join(t.TempDir(), "data")
+	if err := command.Download(torrent, command.PeerAddrs(peers), output, session); err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	if written, _ := os.ReadFile(output); !bytes.Equal(written, data) {
+		t.Errorf("The downloaded data does not match")
+	}
+	if !dropped.Load() || stats.accepted.Load() != 1 {
+		t.Errorf("Expected the peer to be tried again once, got %d connections after the dropped one", stats.accepted.Load())
+	}
+}
+
+// A peer that has every piece and unchokes, but drops the connection on the first request
+func serveDroppingPeer(t *testing.T, pieceCount int) string {
+	return fakePeer(t, func(wire *command.PeerWire) {
+		wire.WriteMessage(decoder.BitfieldMessage(fullBitfield(pieceCount)), decoder.UnchokeMessage())
+		for {
+			if pm, err := wire.ReadMessage(); err != nil || pm.Id == decoder.REQUEST {
+				return
+			}
+		}
+	})
+}
+
+func TestDownloadPeerFailsWhileOthersIdle(t *testing.T) {
+	// A single piece: one worker downloads it while the other has nothing to take
+	data := make([]byte, command.BLOCK_LENGTH)
+	rand.New(rand.NewSource(5)).Read(data)
+	torrent := makePieceTorrent(t, data, command.BLOCK_LENGTH)
+	var stats peerStats
+	peers := []string{serveDroppingPeer(t, 1), servePieces(t, data, command.BLOCK_LENGTH, &stats)}
+	for i := 0; i < 5; i++ {
+		output := filepath.Join(t.TempDir(), "data")
+		if err := command.Download(torrent, peers, output, nil); err != nil {
+			t.Fatalf("Expected the idle worker to take the piece back, got %v", err)
+		}
+		if written, _ := os.ReadFile(output); !bytes.Equal(written, data) {
+			t.Errorf("The downloaded data does not match")
+		}
+	}
+}
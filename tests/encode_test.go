@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+)
+
+var EncodeTests = []struct {
+	description   string
+	input         string
+	expected      string
+	expectedError bool
+}{
+	{
+		description: "Encode dictionary with sorted keys",
+		input:       `{"hello":52,"foo":"bar"}`,
+		expected:    "d3:foo3:bar5:helloi52ee",
+	},
+	{
+		description: "Encode hex byte string",
+		input:       `["a",{"$hex":"ff00"}]`,
+		expected:    "l1:a2:\xff\x00e",
+	},
+	{
+		description: "Encode dictionary pairs with a binary key",
+		input:       `{"$dict":[[{"$hex":"ff"},1],["$hex","x"]]}`,
+		expected:    "d4:$hex1:x1:\xffi1ee",
+	},
+	{
+		description: "Encode big integer",
+		input:       `18446744073709551616`,
+		expected:    "i18446744073709551616e",
+	},
+	{
+		description:   "Encode float",
+		input:         `1.5`,
+		expectedError: true,
+	},
+	{
+		description:   "Encode boolean",
+		input:         `{"private":true}`,
+		expectedError: true,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	for _, test := range EncodeTests {
+		t.Run(test.description, func(t *testing.T) {
+			encoded, err := command.Encode([]byte(test.input))
+			if test.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", encoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if string(encoded) != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, encoded)
+			}
+		})
+	}
+}
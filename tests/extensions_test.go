@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+// Open both ends of a loopback TCP connection
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestExtensions(t *testing.T) {
+	client, server := connPair(t)
+	received := map[string][]byte{}
+	handler := func(name string) command.ExtensionHandler {
+		return command.ExtensionHandlerFunc(func(payload []byte) error {
+			received[name] = payload
+			return nil
+		})
+	}
+	// Both sides register the same extensions in a different order, so they assign them different ids
+	local, remote := command.NewExtensions(), command.NewExtensions()
+	local.Register(decoder.UT_METADATA, handler(decoder.UT_METADATA))
+	local.Register("ut_pex", handler("ut_pex"))
+	remote.Register("ut_pex", handler("ut_pex"))
+	remote.Register(decoder.UT_METADATA, handler(decoder.UT_METADATA))
+	if _, err := local.Register("ut_pex", handler("ut_pex")); err == nil {
+		t.Errorf("Expected registering an extension twice to fail")
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := remote.Exchange(command.NewPeerWire(server), 1234)
+		done <- err
+	}()
+	peer, err := local.Exchange(command.NewPeerWire(client), 0)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	if peer.MetadataSize != 1234 || peer.V != command.CLIENT_VERSION || peer.Reqq != command.LOCAL_REQQ || peer.YourIP != "\x7f\x00\x00\x01" {
+		t.Errorf("Unexpected peer handshake %+v", peer)
+	}
+
+	t.Run("Dispatch messages to the handler of their extension", func(t *testing.T) {
+		for _, name := range []string{decoder.UT_METADATA, "ut_pex"} {
+			// The remote side sends with the ids we advertised, we dispatch them with our own registry
+			message, err := remote.Message(name, []byte(name))
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if err := local.Dispatch(message); err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if string(received[name]) != name {
+				t.Errorf("Expected %s to receive its message, got %q", name, received[name])
+			}
+		}
+		if err := local.Dispatch(decoder.ExtendedMessage(42, nil)); err == nil {
+			t.Errorf("Expected an unknown extended message id to be rejected")
+		}
+	})
+
+	t.Run("Later handshakes update the peer's extensions", func(t *testing.T) {
+		update, _ := decoder.ExtendedHandshakeMessage(&decoder.ExtendedHandshake{M: map[string]int{"ut_pex": 0}})
+		if err := local.Dispatch(update); err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if _, ok := local.PeerID("ut_pex"); ok {
+			t.Errorf("Expected ut_pex to be disabled")
+		}
+		if _, ok := local.PeerID(decoder.UT_METADATA); !ok {
+			t.Errorf("Expected ut_metadata to still be supported")
+		}
+	})
+
+	t.Run("Later handshakes without m keep the peer's extensions", func(t *testing.T) {
+		if err := local.Dispatch(decoder.ExtendedMessage(decoder.EXTENDED_HANDSHAKE_ID, []byte("d4:reqqi10ee"))); err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if _, ok := local.PeerID(decoder.UT_METADATA); !ok || local.Peer().Reqq != 10 {
+			t.Errorf("Expected ut_metadata to still be supported with a reqq of 10, got %+v", local.Peer())
+		}
+	})
+}
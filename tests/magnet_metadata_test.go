@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+// Serve the info dictionary over ut_metadata to the first connection, rejecting every request when reject is set
+func serveMetadata(t *testing.T, info []byte, reject bool) string {
+	return fakePeer(t, func(wire *command.PeerWire) {
+		ours, _ := decoder.ExtendedHandshakeMessage(&decoder.ExtendedHandshake{
+			M:            map[string]int{decoder.UT_METADATA: 3},
+			MetadataSize: len(info),
+		})
+		wire.WriteMessage(decoder.BitfieldMessage([]byte{0xff}), ours)
+		var theirs *decoder.ExtendedHandshake
+		for {
+			pm, err := wire.ReadMessage()
+			if err != nil {
+				return
+			}
+			id, payload, err := decoder.DecodeExtendedMessage(pm)
+			if err != nil {
+				continue
+			}
+			if id == decoder.EXTENDED_HANDSHAKE_ID {
+				theirs, _ = decoder.DecodeExtendedHandshake(payload)
+				continue
+			}
+			request, _, _ := decoder.DecodeMetadataMessage(payload)
+			response := decoder.MetadataMessage{MsgType: decoder.METADATA_REJECT, Piece: request.Piece}
+			data := []byte{}
+			if !reject {
+				response.MsgType, response.TotalSize = decoder.METADATA_DATA, len(info)
+				data = info[request.Piece*decoder.METADATA_PIECE_LENGTH : min((request.Piece+1)*decoder.METADATA_PIECE_LENGTH, len(info))]
+			}
+			payload, _ = decoder.EncodeMetadataMessage(&response, data)
+			wire.WriteMessage(decoder.ExtendedMessage(uint8(theirs.M[decoder.UT_METADATA]), payload))
+		}
+	})
+}
+
+func TestFetchMetadata(t *testing.T) {
+	// Enough piece hashes for the info dictionary to span two metadata pieces
+	info, err := encoder.Marshal(decoder.InfoDict{Name: "big", Length: 1000 * 16384, PieceLength: 16384, Pieces: strings.Repeat("h", 20000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash := utils.SHA1Hash(info)
+
+	t.Run("Fetch the metadata from the peer that has it", func(t *testing.T) {
+		peers := []string{serveMetadata(t, info, true), serveMetadata(t, info, false)}
+		metadata, err := command.FetchMetadata(&decoder.MagnetLink{InfoHash: infoHash}, peers)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if !bytes.Equal(metadata, info) {
+			t.Errorf("Expected the info dictionary, got %d bytes", len(metadata))
+		}
+	})
+
+	t.Run("A peer lying about the metadata size does not fail the others", func(t *testing.T) {
+		lie, _ := encoder.Marshal(decoder.InfoDict{Name: "lie", Length: 1, PieceLength: 16384, Pieces: strings.Repeat("l", 20)})
+		for i := 0; i < 3; i++ {
+			peers := []string{serveMetadata(t, lie, false), serveMetadata(t, info, false)}
+			metadata, err := command.FetchMetadata(&decoder.MagnetLink{InfoHash: infoHash}, peers)
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !bytes.Equal(metadata, info) {
+				t.Errorf("Expected the info dictionary, got %d bytes", len(metadata))
+			}
+		}
+	})
+
+	t.Run("Reject metadata that does not match the info hash", func(t *testing.T) {
+		other := strings.Repeat("\x00", 20)
+		_, err := command.FetchMetadata(&decoder.MagnetLink{InfoHash: other}, []string{serveMetadata(t, info, false)})
+		if err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+func TestParseMagnetLink(t *testing.T) {
+	v1Hash := "\xad\x42\xce\x81\x09\xf5\x4c\x99\x61\x3c\xe3\x8f\x9b\x4d\x87\xe7\x0f\x24\xa1\x65"
+	v2Hash := strings.Repeat("\xab", 32)
+	tests := []struct {
+		description string
+		link        string
+		expected    decoder.MagnetLink
+	}{
+		{
+			"Codecrafters magnet link",
+			"magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&dn=magnet1.gif&tr=http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce",
+			decoder.MagnetLink{
+				InfoHash:    v1Hash,
+				DisplayName: "magnet1.gif",
+				Tracker:     "http://bittorrent-test-tracker.codecrafters.io/announce",
+				Trackers:    []string{"http://bittorrent-test-tracker.codecrafters.io/announce"},
+				WebSeeds:    []string{},
+			},
+		},
+		{
+			"Base32 info hash, escaped name and every parameter",
+			"magnet:?xt=urn:btih:VVBM5AIJ6VGJSYJ44OHZWTMH44HSJILF&dn=my+file%26co.txt&xtr=ignored&tr=udp%3A%2F%2Fa%3A6969&tr=http%3A%2F%2Fb%2Fannounce%3Fx%3D1" +
+				"&ws=http%3A%2F%2Fseed%2Ffile&xl=1024&x.pe=1.2.3.4%3A6881&x.pe=%5B%3A%3A1%5D%3A6881&so=3,0-1,2",
+			decoder.MagnetLink{
+				InfoHash:    v1Hash,
+				DisplayName: "my file&co.txt",
+				Tracker:     "udp://a:6969",
+				Trackers:    []string{"udp://a:6969", "http://b/announce?x=1"},
+				WebSeeds:    []string{"http://seed/file"},
+				ExactLength: 1024,
+				Peers:       []string{"1.2.3.4:6881", "[::1]:6881"},
+				SelectOnly:  []int{0, 1, 2, 3},
+			},
+		},
+		{
+			"Hybrid magnet link",
+			"magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&xt=urn:btmh:1220" + strings.Repeat("ab", 32),
+			decoder.MagnetLink{InfoHash: v1Hash, InfoHashV2: v2Hash, Trackers: []string{}, WebSeeds: []string{}},
+		},
+		{
+			"v2 only magnet link",
+			"magnet:?xt=urn:btmh:1220" + strings.Repeat("AB", 32),
+			decoder.MagnetLink{InfoHash: v2Hash[:20], InfoHashV2: v2Hash, Trackers: []string{}, WebSeeds: []string{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			magnet, err := decoder.ParseMagnetLink(test.link)
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !reflect.DeepEqual(*magnet, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected.String(), magnet.String())
+			}
+		})
+	}
+
+	invalid := []struct {
+		description string
+		link        string
+	}{
+		{"Not a magnet link", "http://example.com/?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165"},
+		{"Missing info hash", "magnet:?dn=file&tr=http%3A%2F%2Ftracker"},
+		{"Short info hash", "magnet:?xt=urn:btih:ad42ce"},
+		{"Unsupported multihash", "magnet:?xt=urn:btmh:1114" + strings.Repeat("ab", 20)},
+		{"Invalid peer", "magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&x.pe=nope"},
+		{"Invalid selection", "magnet:?xt=urn:btih:ad42ce8109f54c99613ce38f9b4d87e70f24a165&so=4-2"},
+	}
+	for _, test := range invalid {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := decoder.ParseMagnetLink(test.link); err == nil {
+				t.Errorf("Expected an error for %s", test.link)
+			}
+		})
+	}
+}
+
+func TestMagnetFromTorrent(t *testing.T) {
+	rawInfo, _ := encoder.Marshal(decoder.InfoDict{Name: "my file & co.txt", Length: 5, PieceLength: 16384, Pieces: strings.Repeat("x", 20)})
+	tests := []struct {
+		description string
+		urlList     string
+		webSeeds    []string
+	}{
+		{"Single web seed", "19:http://seed/a b.txt", []string{"http://seed/a b.txt"}},
+		{"List of web seeds", "l13:http://seed/a13:http://seed/be", []string{"http://seed/a", "http://seed/b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			content, _ := encoder.Marshal(decoder.MetaInfo{
+				// The announce URL is not repeated in the announce-list, it must not be lost
+				Announce:     "http://a/announce?k=v&x=1",
+				AnnounceList: [][]string{{"udp://b:6969", "udp://c:6969"}, {"udp://b:6969"}},
+				Info:         rawInfo,
+				URLList:      encoder.RawMessage(test.urlList),
+			})
+			torrent, _, err := decoder.DecodeTorrentFile(string(content))
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !reflect.DeepEqual(torrent.WebSeeds, test.webSeeds) {
+				t.Fatalf("Expected web seeds %v, got %v", test.webSeeds, torrent.WebSeeds)
+			}
+			link := command.Magnet(torrent).Encode()
+			magnet, err := decoder.ParseMagnetLink(link)
+			if err != nil {
+				t.Fatalf("Expected %s to parse, got %v", link, err)
+			}
+			trackers := []string{"http://a/announce?k=v&x=1", "udp://b:6969", "udp://c:6969"}
+			if magnet.InfoHash != torrent.InfoHash || magnet.DisplayName != torrent.Name || magnet.ExactLength != 5 ||
+				!slices.Equal(magnet.WebSeeds, test.webSeeds) || len(magnet.Trackers) != 3 || magnet.Trackers[0] != trackers[0] {
+				t.Errorf("Unexpected magnet link %s", link)
+			}
+			for _, tracker := range trackers {
+				if !slices.Contains(magnet.Trackers, tracker) {
+					t.Errorf("Expected tracker %s in %s", tracker, link)
+				}
+			}
+		})
+	}
+
+	t.Run("Hybrid and v2 only links round trip", func(t *testing.T) {
+		for _, magnet := range []decoder.MagnetLink{
+			{InfoHash: strings.Repeat("\x01", 20), InfoHashV2: strings.Repeat("\x02", 32), SelectOnly: []int{0, 2, 3, 4}},
+			{InfoHash: strings.Repeat("\x02", 20), InfoHashV2: strings.Repeat("\x02", 32), Peers: []string{"[::1]:6881"}},
+		} {
+			link := magnet.Encode()
+			if strings.Contains(link, "btih") == (magnet.InfoHash == magnet.InfoHashV2[:20]) {
+				t.Errorf("Unexpected v1 info hash in %s", link)
+			}
+			parsed, err := decoder.ParseMagnetLink(link)
+			if err != nil || parsed.InfoHash != magnet.InfoHash || parsed.InfoHashV2 != magnet.InfoHashV2 ||
+				!slices.Equal(parsed.SelectOnly, magnet.SelectOnly) || !slices.Equal(parsed.Peers, magnet.Peers) {
+				t.Errorf("Expected %s to round trip, got %v (%v)", link, parsed, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+func TestPeerConn(t *testing.T) {
+	client, server := connPair(t)
+	c := command.NewPeerConn(command.NewPeerWire(client), 10)
+	c.Start()
+	defer c.Close()
+	peer := command.NewPeerWire(server)
+	nextEvent := func() command.PeerEvent {
+		select {
+		case event, ok := <-c.Events:
+			if !ok {
+				t.Fatalf("Expected an event, the connection ended with %v", c.Err())
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatalf("Expected an event")
+		}
+		return command.PeerEvent{}
+	}
+	first := decoder.BlockRequest{Index: 3, Begin: 0, Length: 4}
+	second := decoder.BlockRequest{Index: 3, Begin: 4, Length: 4}
+
+	t.Run("Unchoke without a bitfield", func(t *testing.T) {
+		if !c.State().PeerChoking || !c.State().AmChoking {
+			t.Errorf("Expected both sides to start choking, got %+v", c.State())
+		}
+		if err := c.Request(first); err == nil {
+			t.Errorf("Expected requests to fail while choked")
+		}
+		peer.WriteMessage(decoder.UnchokeMessage())
+		if event := nextEvent(); event.Type != command.PEER_UNCHOKED || c.State().PeerChoking {
+			t.Errorf("Expected to be unchoked, got %+v", event)
+		}
+		if c.HasPiece(3) {
+			t.Errorf("Expected the peer to have no pieces")
+		}
+	})
+
+	t.Run("HAVE updates the bitfield", func(t *testing.T) {
+		peer.WriteMessage(decoder.HaveMessage(3))
+		if event := nextEvent(); event.Type != command.PEER_HAVE || !reflect.DeepEqual(event.Pieces, []int{3}) || !c.HasPiece(3) {
+			t.Errorf("Expected piece 3 to be announced, got %+v", event)
+		}
+	})
+
+	t.Run("CHOKE requeues outstanding requests", func(t *testing.T) {
+		if err := c.SetInterested(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Request(first, second); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []uint8{decoder.INTERESTED, decoder.REQUEST, decoder.REQUEST} {
+			if pm, err := peer.ReadMessage(); err != nil || pm.Id != expected {
+				t.Fatalf("Expected %s, got %v (%v)", decoder.MessageNames[expected], pm, err)
+			}
+		}
+		peer.WriteMessage(decoder.PieceMessage(3, 0, []byte("abcd")), decoder.ChokeMessage())
+		if event := nextEvent(); event.Type != command.PEER_BLOCK || string(event.Block.Data) != "abcd" {
+			t.Errorf("Expected the first block, got %+v", event)
+		}
+		if event := nextEvent(); event.Type != command.PEER_CHOKED || !reflect.DeepEqual(event.Requeued, []decoder.BlockRequest{second}) {
+			t.Errorf("Expected the second request to be requeued, got %+v", event)
+		}
+		if c.Outstanding() != 0 || !c.State().AmInterested {
+			t.Errorf("Unexpected state %+v with %d outstanding requests", c.State(), c.Outstanding())
+		}
+	})
+
+	t.Run("Unrequested blocks are dropped", func(t *testing.T) {
+		peer.WriteMessage(decoder.PieceMessage(3, 4, []byte("efgh")), decoder.UnchokeMessage())
+		if event := nextEvent(); event.Type != command.PEER_UNCHOKED {
+			t.Errorf("Expected the block to be dropped, got %+v", event)
+		}
+	})
+
+	t.Run("A late bitfield is merged", func(t *testing.T) {
+		peer.WriteMessage(decoder.BitfieldMessage([]byte{0x80, 0x40}))
+		if event := nextEvent(); event.Type != command.PEER_HAVE || !reflect.DeepEqual(event.Pieces, []int{0, 9}) {
+			t.Errorf("Expected pieces 0 and 9, got %+v", event)
+		}
+		if !c.HasPiece(0) || !c.HasPiece(3) || !c.HasPiece(9) || c.HasPiece(1) {
+			t.Errorf("Unexpected pieces after the bitfield")
+		}
+	})
+
+	t.Run("A second bitfield ends the connection", func(t *testing.T) {
+		peer.WriteMessage(decoder.BitfieldMessage([]byte{0xff, 0xc0}))
+		select {
+		case event, ok := <-c.Events:
+			if ok {
+				t.Errorf("Expected the connection to end, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the connection to end")
+		}
+		if c.Err() == nil {
+			t.Errorf("Expected a protocol error")
+		}
+	})
+}
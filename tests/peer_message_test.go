@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+func TestPeerMessages(t *testing.T) {
+	tests := []struct {
+		description string
+		message     *decoder.PeerMessage
+		parse       func(pm *decoder.PeerMessage) (any, error)
+		expected    any
+		str         string
+	}{
+		{
+			description: "HAVE",
+			message:     decoder.HaveMessage(42),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParseHave(pm) },
+			expected:    uint32(42),
+			str:         "HAVE piece index: 42",
+		},
+		{
+			description: "REQUEST",
+			message:     decoder.RequestMessage(1, 16384, 100),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParseRequest(pm) },
+			expected:    &decoder.BlockRequest{Index: 1, Begin: 16384, Length: 100},
+			str:         "REQUEST piece index: 1 byte offset: 16384 length: 100",
+		},
+		{
+			description: "CANCEL",
+			message:     decoder.CancelMessage(2, 0, 16384),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParseRequest(pm) },
+			expected:    &decoder.BlockRequest{Index: 2, Begin: 0, Length: 16384},
+			str:         "CANCEL piece index: 2 byte offset: 0 length: 16384",
+		},
+		{
+			description: "PIECE",
+			message:     decoder.PieceMessage(3, 32768, []byte("data")),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParsePiece(pm) },
+			expected:    &decoder.Block{Index: 3, Begin: 32768, Data: []byte("data")},
+			str:         "PIECE piece index: 3 byte offset: 32768 length: 4",
+		},
+		{
+			description: "BITFIELD of 10 pieces",
+			message:     decoder.BitfieldMessage([]byte{0xff, 0xc0}),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParseBitfield(pm, 10) },
+			expected:    []byte{0xff, 0xc0},
+			str:         "BITFIELD of 2 bytes",
+		},
+		{
+			description: "PORT",
+			message:     decoder.PortMessage(6881),
+			parse:       func(pm *decoder.PeerMessage) (any, error) { return decoder.ParsePort(pm) },
+			expected:    uint16(6881),
+			str:         "PORT 6881",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// Go through the wire format
+			pm, err := decoder.NewDecoder(test.message.Encode()).DecodePeerMessage()
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			parsed, err := test.parse(pm)
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !reflect.DeepEqual(parsed, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, parsed)
+			}
+			if pm.String() != test.str {
+				t.Errorf("Expected %q, got %q", test.str, pm.String())
+			}
+		})
+	}
+
+	t.Run("Empty messages", func(t *testing.T) {
+		for _, pm := range []*decoder.PeerMessage{decoder.ChokeMessage(), decoder.UnchokeMessage(), decoder.InterestedMessage(), decoder.NotInterestedMessage()} {
+			if err := pm.Validate(); err != nil || len(pm.Encode()) != 5 {
+				t.Errorf("Unexpected %s message %x (%v)", pm, pm.Encode(), err)
+			}
+		}
+	})
+}
+
+func TestMalformedPeerMessages(t *testing.T) {
+	tests := []struct {
+		description string
+		parse       func() error
+	}{
+		{"Short HAVE", func() error {
+			_, err := decoder.ParseHave(decoder.NewPeerMessage(decoder.HAVE, []byte{1, 2, 3}))
+			return err
+		}},
+		{"Short REQUEST", func() error {
+			_, err := decoder.ParseRequest(decoder.NewPeerMessage(decoder.REQUEST, []byte{1, 2, 3, 4}))
+			return err
+		}},
+		{"Long CANCEL", func() error {
+			_, err := decoder.ParseRequest(decoder.NewPeerMessage(decoder.CANCEL, make([]byte, 16)))
+			return err
+		}},
+		{"PIECE without offset", func() error {
+			_, err := decoder.ParsePiece(decoder.NewPeerMessage(decoder.PIECE, []byte{0, 0, 0, 1}))
+			return err
+		}},
+		{"Short PORT", func() error {
+			_, err := decoder.ParsePort(decoder.NewPeerMessage(decoder.PORT, []byte{0x1a}))
+			return err
+		}},
+		{"BITFIELD of the wrong length", func() error {
+			_, err := decoder.ParseBitfield(decoder.BitfieldMessage([]byte{0xff}), 10)
+			return err
+		}},
+		{"BITFIELD with spare bits set", func() error {
+			_, err := decoder.ParseBitfield(decoder.BitfieldMessage([]byte{0xff, 0xe0}), 10)
+			return err
+		}},
+		{"Parse a message of another type", func() error {
+			_, err := decoder.ParseHave(decoder.RequestMessage(0, 0, 1))
+			return err
+		}},
+		{"Length prefix larger than the data", func() error {
+			_, err := decoder.NewDecoder([]byte{0, 0, 0, 9, decoder.HAVE, 0, 0, 0, 1}).DecodePeerMessage()
+			return err
+		}},
+		{"Truncated length prefix", func() error {
+			_, err := decoder.NewDecoder([]byte{0, 0}).DecodePeerMessage()
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if err := test.parse(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+
+	t.Run("String of a malformed REQUEST", func(t *testing.T) {
+		if s := decoder.NewPeerMessage(decoder.REQUEST, []byte{1}).String(); !strings.Contains(s, "malformed") {
+			t.Errorf("Expected the message to be reported as malformed, got %q", s)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+// Decode a single-file torrent of the given data
+func makePieceTorrent(t *testing.T, data []byte, pieceLength int) *decoder.TorrentFile {
+	pieces := ""
+	for i := 0; i < len(data); i += pieceLength {
+		pieces += utils.SHA1Hash(data[i:min(i+pieceLength, len(data))])
+	}
+	torrent, _, err := decoder.DecodeTorrentFile(makeTorrent(t, decoder.InfoDict{Name: "data", Length: len(data), PieceLength: pieceLength, Pieces: pieces}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrent
+}
+
+// Write data in small chunks, so that messages are split over several reads
+func dribble(conn net.Conn, data []byte) {
+	for i := 0; i < len(data); i += 7 {
+		conn.Write(data[i:min(i+7, len(data))])
+	}
+}
+
+// The bitfield of a peer that has all the pieces
+func fullBitfield(pieceCount int) []byte {
+	bitfield := bytes.Repeat([]byte{0xff}, (pieceCount+7)/8)
+	if spare := pieceCount % 8; spare != 0 {
+		bitfield[len(bitfield)-1] = 0xff << (8 - spare)
+	}
+	return bitfield
+}
+
+// Connections made to a fake peer
+type peerStats struct {
+	accepted atomic.Int32
+	open     atomic.Int32
+}
+
+// Run a fake peer handing every connection to handle once the handshake, with the extension bit set, is done
+// The connection is closed when handle returns
+func fakePeer(t *testing.T, handle func(wire *command.PeerWire)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handshake := make([]byte, decoder.HANDSHAKE_LENGTH)
+				if _, err := io.ReadFull(conn, handshake); err != nil {
+					return
+				}
+				conn.Write(encoder.MakeHandshakeMessage(string(handshake[28:48]), strings.Repeat("p", 20), true))
+				handle(command.NewPeerWire(conn))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Seed data to every connection, see seedPieces
+func servePieces(t *testing.T, data []byte, pieceLength int, stats *peerStats) string {
+	return fakePeer(t, seedPieces(data, pieceLength, stats))
+}
+
+// Seed data to a connection: announce a HAVE before the BITFIELD, unchoke interested peers,
+// and answer the requests of each piece in reverse order, interleaved with HAVE messages and keep-alives
+func seedPieces(data []byte, pieceLength int, stats *peerStats) func(wire *command.PeerWire) {
+	return func(wire *command.PeerWire) {
+		stats.accepted.Add(1)
+		stats.open.Add(1)
+		defer stats.open.Add(-1)
+		dribble(wire.Conn(), append(decoder.HaveMessage(0).Encode(), decoder.BitfieldMessage(fullBitfield((len(data)+pieceLength-1)/pieceLength)).Encode()...))
+		var pending []*decoder.PeerMessage
+		pendingLength := 0
+		for {
+			pm, err := wire.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch pm.Id {
+			case decoder.INTERESTED:
+				wire.WriteMessage(decoder.UnchokeMessage())
+			case decoder.REQUEST:
+				r, _ := decoder.ParseRequest(pm)
+				offset := int(r.Index)*pieceLength + int(r.Begin)
+				pending = append(pending, decoder.PieceMessage(r.Index, r.Begin, data[offset:offset+int(r.Length)]))
+				pendingLength += int(r.Length)
+				if pendingLength < min(pieceLength, len(data)-int(r.Index)*pieceLength) {
+					continue
+				}
+				var out []byte
+				for i := len(pending) - 1; i >= 0; i-- {
+					out = append(out, 0, 0, 0, 0)
+					out = append(out, decoder.HaveMessage(uint32(i)).Encode()...)
+					out = append(out, pending[i].Encode()...)
+				}
+				dribble(wire.Conn(), out)
+				pending, pendingLength = nil, 0
+			}
+		}
+	}
+}
+
+func TestPeerWire(t *testing.T) {
+	client, server := connPair(t)
+	wire := command.NewPeerWire(client)
+	messages := []*decoder.PeerMessage{
+		decoder.UnchokeMessage(),
+		decoder.HaveMessage(7),
+		decoder.BitfieldMessage(fullBitfield(20000)),
+		decoder.NewPeerMessage(decoder.PIECE, make([]byte, 8+command.BLOCK_LENGTH)),
+	}
+	var stream []byte
+	for _, pm := range messages {
+		stream = append(append(stream, 0, 0, 0, 0), pm.Encode()...)
+	}
+	go dribble(server, stream)
+
+	t.Run("Read split and coalesced messages, skipping keep-alives", func(t *testing.T) {
+		for _, expected := range messages {
+			pm, err := wire.ReadMessage()
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if pm.Id != expected.Id || !bytes.Equal(pm.Payload, expected.Payload) {
+				t.Errorf("Expected %s, got %s", expected, pm)
+			}
+		}
+	})
+
+	tests := []struct {
+		description string
+		message     []byte
+	}{
+		{"HAVE with a short payload", decoder.NewPeerMessage(decoder.HAVE, []byte{0, 1}).Encode()},
+		{"REQUEST with a long payload", decoder.NewPeerMessage(decoder.REQUEST, make([]byte, 13)).Encode()},
+		{"UNCHOKE with a payload", decoder.NewPeerMessage(decoder.UNCHOKE, []byte{1}).Encode()},
+		{"Message over the length limit", binary.BigEndian.AppendUint32(nil, command.MAX_PEER_MESSAGE_LENGTH+1)},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			client, server := connPair(t)
+			server.Write(test.message)
+			if pm, err := command.NewPeerWire(client).ReadMessage(); err == nil {
+				t.Errorf("Expected an error, got %s", pm)
+			}
+		})
+	}
+}
+
+func TestDownloadPiece(t *testing.T) {
+	data := make([]byte, 40000)
+	rand.New(rand.NewSource(1)).Read(data)
+	pieceLength := 2 * command.BLOCK_LENGTH
+	torrent := makePieceTorrent(t, data, pieceLength)
+	peer := servePieces(t, data, pieceLength, &peerStats{})
+	for i := 0; i < torrent.PieceCount(); i++ {
+		piece, err := command.DownloadPiece(peer, torrent, i)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if !bytes.Equal(piece, data[i*pieceLength:min((i+1)*pieceLength, len(data))]) {
+			t.Errorf("Piece %d does not match", i)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+// Requests seen by a fake peer
+type requestStats struct {
+	mu             sync.Mutex
+	outstanding    int
+	maxOutstanding int
+	spanned        bool // Requests for a piece arrived while blocks of an earlier piece were outstanding
+	maxPieces      int  // Most pieces with outstanding requests at once
+	pending        map[uint32]int
+}
+
+// Seed data over a link answering each request after latency, advertising reqq in the extension handshake when not 0
+func serveWithLatency(t *testing.T, data []byte, pieceLength int, latency time.Duration, reqq int, stats *requestStats) string {
+	stats.pending = map[uint32]int{}
+	return fakePeer(t, func(wire *command.PeerWire) {
+		if reqq > 0 {
+			ours, _ := decoder.ExtendedHandshakeMessage(&decoder.ExtendedHandshake{M: map[string]int{}, Reqq: reqq})
+			wire.WriteMessage(ours)
+		}
+		wire.WriteMessage(decoder.BitfieldMessage(fullBitfield((len(data) + pieceLength - 1) / pieceLength)))
+		for {
+			pm, err := wire.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch pm.Id {
+			case decoder.INTERESTED:
+				wire.WriteMessage(decoder.UnchokeMessage())
+			case decoder.REQUEST:
+				r, _ := decoder.ParseRequest(pm)
+				stats.mu.Lock()
+				stats.outstanding++
+				stats.maxOutstanding = max(stats.maxOutstanding, stats.outstanding)
+				for index, count := range stats.pending {
+					if index < r.Index && count > 0 {
+						stats.spanned = true
+					}
+				}
+				stats.pending[r.Index]++
+				pieces := 0
+				for _, count := range stats.pending {
+					if count > 0 {
+						pieces++
+					}
+				}
+				stats.maxPieces = max(stats.maxPieces, pieces)
+				stats.mu.Unlock()
+				offset := int(r.Index)*pieceLength + int(r.Begin)
+				time.AfterFunc(latency, func() {
+					stats.mu.Lock()
+					stats.outstanding--
+					stats.pending[r.Index]--
+					stats.mu.Unlock()
+					wire.WriteMessage(decoder.PieceMessage(r.Index, r.Begin, data[offset:offset+int(r.Length)]))
+				})
+			}
+		}
+	})
+}
+
+func TestRequestPipeline(t *testing.T) {
+	data := make([]byte, 64*command.BLOCK_LENGTH)
+	rand.New(rand.NewSource(3)).Read(data)
+	pieceLength := 2 * command.BLOCK_LENGTH
+	torrent := makePieceTorrent(t, data, pieceLength)
+
+	tests := []struct {
+		description string
+		reqq        int
+		check       func(stats *requestStats) bool
+	}{
+		{"The depth grows past the initial one on a slow link", 0, func(stats *requestStats) bool {
+			return stats.maxOutstanding > command.PIPELINE_INITIAL_DEPTH && stats.spanned
+		}},
+		{"A worker reads at most PIPELINE_MAX_PIECES pieces ahead", 0, func(stats *requestStats) bool {
+			return stats.maxPieces <= command.PIPELINE_MAX_PIECES && stats.maxOutstanding <= 2*command.PIPELINE_MAX_PIECES
+		}},
+		{"The depth is capped by the peer's reqq", 3, func(stats *requestStats) bool {
+			return stats.maxOutstanding <= 3 && stats.spanned
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			stats := &requestStats{}
+			peer := serveWithLatency(t, data, pieceLength, 20*time.Millisecond, test.reqq, stats)
+			output := filepath.Join(t.TempDir(), "data")
+			if err := command.Download(torrent, []string{peer}, output, nil); err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if written, _ := os.ReadFile(output); !bytes.Equal(written, data) {
+				t.Errorf("The downloaded data does not match")
+			}
+			stats.mu.Lock()
+			defer stats.mu.Unlock()
+			if !test.check(stats) {
+				t.Errorf("Unexpected requests: at most %d outstanding over %d pieces, spanning pieces: %v", stats.maxOutstanding, stats.maxPieces, stats.spanned)
+			}
+		})
+	}
+}
+
+// A peer that has every piece and unchokes, then chokes us on the first request and only sends HAVE messages from then on
+func serveChokingPeer(t *testing.T, pieceCount int) string {
+	return fakePeer(t, func(wire *command.PeerWire) {
+		wire.WriteMessage(decoder.BitfieldMessage(fullBitfield(pieceCount)))
+		for {
+			pm, err := wire.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch pm.Id {
+			case decoder.INTERESTED:
+				wire.WriteMessage(decoder.UnchokeMessage())
+			case decoder.REQUEST:
+				wire.WriteMessage(decoder.ChokeMessage())
+				go func() {
+					for i := 0; ; i++ {
+						if err := wire.WriteMessage(decoder.HaveMessage(uint32(i % pieceCount))); err != nil {
+							return
+						}
+						time.Sleep(10 * time.Millisecond)
+					}
+				}()
+			}
+		}
+	})
+}
+
+func TestPipelineChokedPeer(t *testing.T) {
+	data := make([]byte, 16*command.BLOCK_LENGTH)
+	rand.New(rand.NewSource(7)).Read(data)
+	torrent := makePieceTorrent(t, data, command.BLOCK_LENGTH)
+	var stats peerStats
+	peers := []string{serveChokingPeer(t, 16), servePieces(t, data, command.BLOCK_LENGTH, &stats)}
+	output := filepath.Join(t.TempDir(), "data")
+	done := make(chan error, 1)
+	go func() { done <- command.Download(torrent, peers, output, nil) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected the pieces of the choking peer to go to the other one")
+	}
+	if written, _ := os.ReadFile(output); !bytes.Equal(written, data) {
+		t.Errorf("The downloaded data does not match")
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		description string
+		announce    string
+		expected    string
+	}{
+		{"Plain announce", "http://example.com/announce", "http://example.com/scrape"},
+		{"Announce with a suffix and a query", "http://example.com/x/announce.php?passkey=abc", "http://example.com/x/scrape.php?passkey=abc"},
+		{"Announce not in the last component", "http://example.com/announce/x", ""},
+		{"Other name", "http://example.com/a", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			scrapeURL, err := command.ScrapeURL(test.announce)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("Expected an error, got %s", scrapeURL)
+				}
+				return
+			}
+			if err != nil || scrapeURL != test.expected {
+				t.Errorf("Expected %s, got %s (%v)", test.expected, scrapeURL, err)
+			}
+		})
+	}
+}
+
+func TestScrapeTorrents(t *testing.T) {
+	// The HTTP tracker reports the first byte of each info hash as its number of seeders
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			http.NotFound(w, r)
+			return
+		}
+		files := map[string]decoder.ScrapeFile{}
+		for _, infoHash := range r.URL.Query()["info_hash"] {
+			files[infoHash] = decoder.ScrapeFile{Complete: int(infoHash[0]), Downloaded: 9, Incomplete: 4}
+		}
+		response, _ := encoder.Marshal(decoder.ScrapeResponse{Files: files})
+		w.Write(response)
+	}))
+	defer server.Close()
+	var connects atomic.Int32
+	udpTracker := serveUDPTracker(t, &connects)
+	// Get the retransmission out of the way with a short timeout, the connection ID is then cached
+	warmup, _ := netclient.NewUDPTracker(udpTracker)
+	warmup.Timeout = 50 * time.Millisecond
+	warmup.Scrape([]string{strings.Repeat("a", 20)})
+
+	dir := t.TempDir()
+	paths := []string{}
+	infoHashes := []string{}
+	for i, name := range []string{"first", "second"} {
+		rawInfo, _ := encoder.Marshal(decoder.InfoDict{Name: name, Length: 10 + i, PieceLength: 16384, Pieces: strings.Repeat("x", 20)})
+		content, _ := encoder.Marshal(decoder.MetaInfo{
+			Announce:     server.URL + "/announce",
+			AnnounceList: [][]string{{server.URL + "/announce"}, {udpTracker}},
+			Info:         rawInfo,
+		})
+		path := filepath.Join(dir, name+".torrent")
+		os.WriteFile(path, content, 0o644)
+		paths = append(paths, path)
+		torrent, _, _ := decoder.DecodeTorrentFile(string(content))
+		infoHashes = append(infoHashes, torrent.InfoHash)
+	}
+
+	results, err := command.ScrapeTorrents(paths)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected a result per torrent and tracker, got %+v", results)
+	}
+	for i, r := range results {
+		infoHash := infoHashes[i/2]
+		if r.InfoHash != fmt.Sprintf("%x", infoHash) || r.Error != "" {
+			t.Errorf("Unexpected result %+v", r)
+		}
+		// The UDP tracker reports 5 completed downloads and 1 leecher
+		expected := command.ScrapeResult{Name: r.Name, InfoHash: r.InfoHash, Tracker: server.URL + "/announce", Seeders: int(infoHash[0]), Leechers: 4, Completed: 9}
+		if r.Tracker == udpTracker {
+			expected.Tracker, expected.Leechers, expected.Completed = udpTracker, 1, 5
+		}
+		if r != expected {
+			t.Errorf("Expected %+v, got %+v", expected, r)
+		}
+	}
+	if table := command.FormatScrapeResults(results); !strings.Contains(table, "first") || strings.Count(table, "\n") != 5 {
+		t.Errorf("Unexpected table\n%s", table)
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/utils"
+)
+
+const v2PieceLength = 16 * 1024
+
+// Hash a layer of the merkle tree up to its root, the layer must have a power of two length
+func merkleRoot(layer [][]byte) []byte {
+	for len(layer) > 1 {
+		next := make([][]byte, 0, len(layer)/2)
+		for i := 0; i < len(layer); i += 2 {
+			h := sha256.Sum256(append(append([]byte{}, layer[i]...), layer[i+1]...))
+			next = append(next, h[:])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+// With a piece length of one block, the piece hashes are the block hashes and the pieces root the root over them
+func v2FileHashes(data []byte) (root []byte, layer string) {
+	leaves := [][]byte{}
+	for i := 0; i < len(data); i += v2PieceLength {
+		h := sha256.Sum256(data[i:min(i+v2PieceLength, len(data))])
+		leaves = append(leaves, h[:])
+		layer += string(h[:])
+	}
+	for len(leaves)&(len(leaves)-1) != 0 {
+		leaves = append(leaves, make([]byte, 32))
+	}
+	return merkleRoot(leaves), layer
+}
+
+// Build a torrent with a big file and a small file, hybrid torrents also get a v1 file list with a padding file
+func makeV2Torrent(t *testing.T, big, small []byte, hybrid bool) string {
+	bigRoot, bigLayer := v2FileHashes(big)
+	smallRoot, _ := v2FileHashes(small)
+	tree, err := encoder.Marshal(map[string]interface{}{
+		"big.bin": map[string]interface{}{"": encoder.FileTreeEntry{Length: len(big), PiecesRoot: string(bigRoot)}},
+		"small.txt": map[string]interface{}{
+			"": encoder.FileTreeEntry{Length: len(small), PiecesRoot: string(smallRoot)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := encoder.InfoDict{FileTree: tree, MetaVersion: 2, Name: "v2", PieceLength: v2PieceLength}
+	if hybrid {
+		padding := v2PieceLength - len(big)%v2PieceLength
+		data := append(append(append([]byte{}, big...), make([]byte, padding)...), small...)
+		for i := 0; i < len(data); i += v2PieceLength {
+			info.Pieces += utils.SHA1Hash(data[i:min(i+v2PieceLength, len(data))])
+		}
+		info.Files = []encoder.InfoFile{
+			{Length: len(big), Path: []string{"big.bin"}},
+			{Attr: "p", Length: padding, Path: []string{".pad", fmt.Sprint(padding)}},
+			{Length: len(small), Path: []string{"small.txt"}},
+		}
+	}
+	rawInfo, err := encoder.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := encoder.Marshal(encoder.MetaInfo{
+		Announce:    "http://tracker/announce",
+		Info:        rawInfo,
+		PieceLayers: map[string]string{string(bigRoot): bigLayer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestDecodeV2Torrent(t *testing.T) {
+	big := make([]byte, 40000)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	small := []byte("hello v2")
+	tests := []struct {
+		description string
+		hybrid      bool
+		pieceSizes  []int
+	}{
+		{"v2 only torrent", false, []int{16384, 16384, 7232, 8}},
+		{"hybrid torrent", true, []int{16384, 16384, 16384, 8}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			content := makeV2Torrent(t, big, small, test.hybrid)
+			torrent, _, err := decoder.DecodeTorrentFile(content)
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			var meta decoder.MetaInfo
+			if err := decoder.Unmarshal([]byte(content), &meta); err != nil {
+				t.Fatal(err)
+			}
+			if torrent.MetaVersion != 2 || torrent.InfoHashV2 != utils.SHA256Hash(meta.Info) {
+				t.Errorf("Unexpected v2 info hash %x", torrent.InfoHashV2)
+			}
+			expectedHash := utils.SHA1Hash(meta.Info)
+			if !test.hybrid {
+				expectedHash = torrent.InfoHashV2[:20]
+			}
+			if torrent.InfoHash != expectedHash {
+				t.Errorf("Expected info hash %x, got %x", expectedHash, torrent.InfoHash)
+			}
+			sizes := []int{}
+			for i := 0; i < torrent.PieceCount(); i++ {
+				sizes = append(sizes, torrent.PieceSize(i))
+			}
+			if !reflect.DeepEqual(sizes, test.pieceSizes) {
+				t.Fatalf("Expected piece sizes %v, got %v", test.pieceSizes, sizes)
+			}
+
+			// Pieces are laid out with the small file starting on a new piece
+			data := append(append(append([]byte{}, big...), make([]byte, 16384*3-len(big))...), small...)
+			offset := 0
+			for i, size := range sizes {
+				piece := data[offset : offset+size]
+				offset += max(size, 16384)
+				if err := torrent.VerifyPiece(i, piece); err != nil {
+					t.Errorf("Expected piece %d to be valid, got %v", i, err)
+				}
+				corrupted := append([]byte{}, piece...)
+				corrupted[0] ^= 0xff
+				if err := torrent.VerifyPiece(i, corrupted); err == nil {
+					t.Errorf("Expected corrupted piece %d to be rejected", i)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid piece layer", func(t *testing.T) {
+		content := makeV2Torrent(t, big, small, false)
+		bigRoot, layer := v2FileHashes(big)
+		var meta decoder.MetaInfo
+		decoder.Unmarshal([]byte(content), &meta)
+		meta.PieceLayers[string(bigRoot)] = layer[:len(layer)-1] + "x"
+		corrupted, _ := encoder.Marshal(meta)
+		if _, _, err := decoder.DecodeTorrentFile(string(corrupted)); err == nil {
+			t.Errorf("Expected a piece layer that does not match the pieces root to be rejected")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+)
+
+func TestTrackerResponsePeerList(t *testing.T) {
+	tests := []struct {
+		description string
+		response    string
+		expected    []decoder.Peer
+		wantErr     bool
+	}{
+		{
+			description: "Compact IPv4 peers",
+			response:    "d8:intervali900e5:peers12:\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50e",
+			expected: []decoder.Peer{
+				{Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+				{Addr: netip.MustParseAddrPort("192.168.1.2:80")},
+			},
+		},
+		{
+			description: "Compact IPv4 and IPv6 peers",
+			response:    "d5:peers6:\x0a\x00\x00\x01\x1a\xe16:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe2e",
+			expected: []decoder.Peer{
+				{Addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+				{Addr: netip.MustParseAddrPort("[2001:db8::1]:6882")},
+			},
+		},
+		{
+			description: "Dictionary peers with their IDs, DNS names are kept",
+			response:    "d5:peersld2:ip8:10.0.0.17:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881eed2:ip7:2001::14:porti6882eed2:ip11:example.com4:porti1eeee",
+			expected: []decoder.Peer{
+				{Addr: netip.MustParseAddrPort("10.0.0.1:6881"), ID: "aaaaaaaaaaaaaaaaaaaa"},
+				{Addr: netip.MustParseAddrPort("[2001::1]:6882")},
+				{Host: "example.com:1"},
+			},
+		},
+		{
+			description: "Dictionary peers with an invalid port or ip are skipped",
+			response:    "d5:peersld2:ip8:10.0.0.14:porti70000eed2:ip9:bad name!4:porti1eed2:ip8:10.0.0.24:porti6881eeee",
+			expected:    []decoder.Peer{{Addr: netip.MustParseAddrPort("10.0.0.2:6881")}},
+		},
+		{
+			description: "No peers",
+			response:    "d8:intervali900ee",
+			expected:    nil,
+		},
+		{
+			description: "Truncated compact peers",
+			response:    "d5:peers5:\x0a\x00\x00\x01\x1ae",
+			wantErr:     true,
+		},
+		{
+			description: "Invalid peers list",
+			response:    "d5:peersli1eee",
+			wantErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var response decoder.TrackerResponse
+			if err := decoder.Unmarshal([]byte(test.response), &response); err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			peers, err := response.PeerList()
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", peers)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if !reflect.DeepEqual(peers, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, peers)
+			}
+		})
+	}
+}
+
+func TestAnnounceFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason15:unknown torrente"))
+	}))
+	defer server.Close()
+	_, err := command.Peers(server.URL+"/announce", "hhhhhhhhhhhhhhhhhhhh", 100)
+	var failure *command.TrackerFailure
+	if !errors.As(err, &failure) || failure.Reason != "unknown torrent" || failure.Tracker != server.URL+"/announce" {
+		t.Errorf("Expected a tracker failure, got %v", err)
+	}
+}
+
+func TestAnnounceErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>Service Unavailable</html>", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	_, err := command.Peers(server.URL+"/announce", "hhhhhhhhhhhhhhhhhhhh", 100)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the status to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+)
+
+func TestTrackerServer(t *testing.T) {
+	infoHash := strings.Repeat("h", 20)
+	tracker := command.NewTrackerServer()
+	tracker.Allowed = map[string]bool{infoHash: true}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+	announceURL := server.URL + "/announce"
+
+	// Announce with the given parameters and decode the response
+	announce := func(params map[string]string) *decoder.TrackerResponse {
+		query := url.Values{"info_hash": {infoHash}, "port": {"6881"}, "left": {"0"}}
+		for key, value := range params {
+			query.Set(key, value)
+		}
+		resp, err := http.Get(announceURL + "?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var response decoder.TrackerResponse
+		if err := decoder.Unmarshal(body, &response); err != nil {
+			t.Fatalf("Expected error to be nil, got %v for %q", err, body)
+		}
+		return &response
+	}
+	seeder := strings.Repeat("s", 20)
+	leecher := strings.Repeat("l", 20)
+	announce(map[string]string{"peer_id": seeder, "ip": "10.0.0.1", "left": "100", "event": "started"})
+	// A repeated completed event counts once
+	announce(map[string]string{"peer_id": seeder, "ip": "10.0.0.1", "event": "completed"})
+	announce(map[string]string{"peer_id": seeder, "ip": "10.0.0.1", "event": "completed"})
+	announce(map[string]string{"peer_id": leecher, "ip": "2001:db8::1", "port": "6882", "left": "100", "event": "started"})
+
+	t.Run("Compact IPv4 and IPv6 peers", func(t *testing.T) {
+		peers, err := command.Peers(announceURL, infoHash, 100)
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		addrs := command.PeerAddrs(peers)
+		slices.Sort(addrs)
+		expected := []string{"10.0.0.1:6881", "[2001:db8::1]:6882"}
+		if !reflect.DeepEqual(addrs, expected) {
+			t.Errorf("Expected %v, got %v", expected, addrs)
+		}
+	})
+
+	t.Run("Dictionary peers with their IDs", func(t *testing.T) {
+		response := announce(map[string]string{"peer_id": leecher, "ip": "2001:db8::1", "port": "6882", "left": "100"})
+		peers, err := response.PeerList()
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		// The client that announced in the previous test is a peer too, but not the leecher itself
+		ids := []string{}
+		for _, p := range peers {
+			ids = append(ids, p.ID)
+		}
+		if len(peers) != 2 || !slices.Contains(ids, seeder) || slices.Contains(ids, leecher) || response.Interval != 1800 {
+			t.Errorf("Unexpected response %+v with peers %v", response, peers)
+		}
+	})
+
+	t.Run("Scrape", func(t *testing.T) {
+		stats, err := command.Scrape(announceURL, []string{infoHash})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		expected := netclient.ScrapeStats{Seeders: 1, Completed: 1, Leechers: 2}
+		if stats[infoHash] != expected {
+			t.Errorf("Expected %+v, got %+v", expected, stats[infoHash])
+		}
+	})
+
+	t.Run("Stopped peers leave the swarm", func(t *testing.T) {
+		announce(map[string]string{"peer_id": leecher, "ip": "2001:db8::1", "left": "100", "event": "stopped"})
+		stats, _ := command.Scrape(announceURL, []string{infoHash})
+		if stats[infoHash].Leechers != 1 {
+			t.Errorf("Expected 1 leecher, got %+v", stats[infoHash])
+		}
+	})
+
+	t.Run("Unknown torrent", func(t *testing.T) {
+		_, err := command.Peers(announceURL, strings.Repeat("x", 20), 100)
+		var failure *command.TrackerFailure
+		if !errors.As(err, &failure) || failure.Reason != "unknown torrent" {
+			t.Errorf("Expected a tracker failure, got %v", err)
+		}
+	})
+
+	t.Run("Peers expire", func(t *testing.T) {
+		tracker.PeerTTL = 20 * time.Millisecond
+		time.Sleep(50 * time.Millisecond)
+		stats, _ := command.Scrape(announceURL, []string{infoHash})
+		if stats[infoHash].Seeders != 0 || stats[infoHash].Leechers != 0 || stats[infoHash].Completed != 1 {
+			t.Errorf("Expected every peer to expire, got %+v", stats[infoHash])
+		}
+	})
+}
+
+func TestTrackerServerIP(t *testing.T) {
+	infoHash := strings.Repeat("h", 20)
+	tests := []struct {
+		description string
+		remoteAddr  string
+		trustIP     bool
+		expected    string
+	}{
+		{"Honored from a loopback address", "127.0.0.1:40000", false, "10.0.0.1:6881"},
+		{"Honored from a private address", "192.168.1.2:40000", false, "10.0.0.1:6881"},
+		{"Ignored from a public address", "192.0.2.1:40000", false, "192.0.2.1:6881"},
+		{"Honored from a public address when trusted", "192.0.2.1:40000", true, "10.0.0.1:6881"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			tracker := command.NewTrackerServer()
+			tracker.TrustIP = test.trustIP
+			query := url.Values{"info_hash": {infoHash}, "port": {"6881"}, "left": {"0"}, "ip": {"10.0.0.1"}}
+			query.Set("peer_id", strings.Repeat("a", 20))
+			r := httptest.NewRequest("GET", "/announce?"+query.Encode(), nil)
+			r.RemoteAddr = test.remoteAddr
+			tracker.ServeHTTP(httptest.NewRecorder(), r)
+
+			// A second peer gets the first one back
+			query.Set("peer_id", strings.Repeat("b", 20))
+			recorder := httptest.NewRecorder()
+			tracker.ServeHTTP(recorder, httptest.NewRequest("GET", "/announce?"+query.Encode(), nil))
+			var response decoder.TrackerResponse
+			if err := decoder.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			peers, err := response.PeerList()
+			if err != nil || len(peers) != 1 || peers[0].String() != test.expected {
+				t.Errorf("Expected the peer %s, got %v (%v)", test.expected, peers, err)
+			}
+		})
+	}
+}
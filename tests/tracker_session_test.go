@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+func TestTrackerSession(t *testing.T) {
+	var mu sync.Mutex
+	var announces []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		announces = append(announces, r.URL.Query())
+		mu.Unlock()
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800, TrackerID: "session-1", Peers: decoder.RawMessage("6:\x0a\x00\x00\x01\x1a\xe1")})
+		w.Write(response)
+	}))
+	defer server.Close()
+	lastAnnounce := func() url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return announces[len(announces)-1]
+	}
+
+	infoHash := strings.Repeat("h", 20)
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), infoHash, 1000)
+	reannounced := make(chan []decoder.Peer, 1)
+	session.SetOnPeers(func(peers []decoder.Peer) { reannounced <- peers })
+
+	tests := []struct {
+		description string
+		action      func() error
+		expected    map[string]string
+	}{
+		{"Started", func() error {
+			peers, err := session.Start()
+			if len(peers) != 1 || peers[0].String() != "10.0.0.1:6881" {
+				t.Errorf("Unexpected peers %v", peers)
+			}
+			return err
+		}, map[string]string{"event": "started", "left": "1000", "downloaded": "0", "trackerid": ""}},
+		{"Re-announce with the stats and the tracker id", func() error {
+			session.AddDownloaded(600)
+			session.SetLeft(400)
+			session.AddUploaded(50)
+			session.Reannounce()
+			select {
+			case <-reannounced:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected a re-announce")
+			}
+			return nil
+		}, map[string]string{"event": "", "left": "400", "downloaded": "600", "uploaded": "50", "trackerid": "session-1"}},
+		{"Completed", func() error {
+			session.AddDownloaded(400)
+			return session.Completed()
+		}, map[string]string{"event": "completed", "left": "0", "downloaded": "1000"}},
+		{"Stopped", session.Stop, map[string]string{"event": "stopped", "trackerid": "session-1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if err := test.action(); err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			query := lastAnnounce()
+			for key, value := range test.expected {
+				if query.Get(key) != value {
+					t.Errorf("Expected %s=%q, got %q", key, value, query.Get(key))
+				}
+			}
+			if query.Get("info_hash") != infoHash || query.Get("peer_id") != command.PeerID || query.Get("key") == "" {
+				t.Errorf("Unexpected announce %v", query)
+			}
+		})
+	}
+	if len(announces) != 4 {
+		t.Errorf("Expected 4 announces, got %d", len(announces))
+	}
+	if _, err := session.Start(); err == nil {
+		t.Errorf("Expected a stopped session not to start again")
+	}
+}
+
+func TestTrackerSessionSlowReannounce(t *testing.T) {
+	release := make(chan struct{})
+	reannouncing := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Regular announces hang until the end of the test
+		if r.URL.Query().Get("event") == "" {
+			reannouncing <- struct{}{}
+			<-release
+		}
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800})
+		w.Write(response)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), strings.Repeat("h", 20), 1000)
+	if _, err := session.Start(); err != nil {
+		t.Fatal(err)
+	}
+	session.Reannounce()
+	select {
+	case <-reannouncing:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a re-announce")
+	}
+	done := make(chan error, 2)
+	go func() {
+		done <- session.Completed()
+		done <- session.Stop()
+	}()
+	for _, event := range []string{"completed", "stopped"} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Expected %s to succeed, got %v", event, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s not to wait for the re-announce", event)
+		}
+	}
+}
+
+func TestTrackerSessionCompletedOnce(t *testing.T) {
+	var mu sync.Mutex
+	completed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("event") == "completed" {
+			mu.Lock()
+			completed++
+			mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+		}
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800})
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), strings.Repeat("h", 20), 1000)
+	reannounced := make(chan []decoder.Peer, 1)
+	session.SetOnPeers(func(peers []decoder.Peer) { reannounced <- peers })
+	if _, err := session.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer session.Stop()
+	done := make(chan error, 1)
+	go func() { done <- session.Completed() }()
+	// A re-announce while the completed event is in flight must not send it again
+	time.Sleep(50 * time.Millisecond)
+	session.Reannounce()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected error to be nil, got %v", err)
+	}
+	select {
+	case <-reannounced:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a re-announce")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if completed != 1 {
+		t.Errorf("Expected the completed event to be sent once, got %d", completed)
+	}
+}
+
+func TestTrackerSessionFinalTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the started event is answered
+		if r.URL.Query().Get("event") != "started" {
+			<-release
+		}
+		response, _ := encoder.Marshal(decoder.TrackerResponse{Interval: 1800})
+		w.Write(response)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	session := command.NewTrackerSession(command.NewTrackerTiers([][]string{{server.URL + "/announce"}}), strings.Repeat("h", 20), 1000)
+	session.FinalTimeout = 100 * time.Millisecond
+	if _, err := session.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, final := range []struct {
+		event  string
+		action func() error
+	}{{"completed", session.Completed}, {"stopped", session.Stop}} {
+		done := make(chan error, 1)
+		go func() { done <- final.action() }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("Expected the %s event to time out", final.event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the %s event not to wait for the tracker", final.event)
+		}
+	}
+}
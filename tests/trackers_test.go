@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+)
+
+func TestTrackerTiers(t *testing.T) {
+	t.Run("Fall back to the next tiers and promote the tracker that responds", func(t *testing.T) {
+		tiers := command.NewTrackerTiers([][]string{{"dead1"}, {"dead2", "alive", "dead3"}, {"unused"}})
+		tried := []string{}
+		tracker, err := tiers.Announce(func(tracker string, _ bool) error {
+			tried = append(tried, tracker)
+			if tracker != "alive" {
+				return fmt.Errorf("unreachable")
+			}
+			return nil
+		})
+		if err != nil || tracker != "alive" {
+			t.Fatalf("Expected alive to respond, got %q (%v)", tracker, err)
+		}
+		if tried[0] != "dead1" || slices.Contains(tried, "unused") {
+			t.Errorf("Unexpected order of trackers %v", tried)
+		}
+		second := tiers.Tiers()[1]
+		if second[0] != "alive" || len(second) != 3 {
+			t.Errorf("Expected alive to be first in its tier, got %v", second)
+		}
+	})
+	t.Run("Every tracker fails", func(t *testing.T) {
+		tiers := command.NewTrackerTiers([][]string{{"a", "b"}, {"c"}})
+		fallbacks := []bool{}
+		if _, err := tiers.Announce(func(_ string, fallback bool) error {
+			fallbacks = append(fallbacks, fallback)
+			return fmt.Errorf("down")
+		}); err == nil {
+			t.Errorf("Expected an error")
+		}
+		if !slices.Equal(fallbacks, []bool{true, true, false}) {
+			t.Errorf("Expected only the last tracker to have no fallback, got %v", fallbacks)
+		}
+	})
+}
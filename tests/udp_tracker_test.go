@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/netclient"
+)
+
+// Run a UDP tracker that ignores the first packet it receives, so that clients have to retransmit
+func serveUDPTracker(t *testing.T, connects *atomic.Int32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	const connID = 0x1234
+	go func() {
+		buff := make([]byte, 2048)
+		for first := true; ; first = false {
+			n, addr, err := conn.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			if first {
+				continue
+			}
+			request := buff[:n]
+			action, txid := binary.BigEndian.Uint32(request[8:12]), request[12:16]
+			response := binary.BigEndian.AppendUint32(nil, action)
+			response = append(response, txid...)
+			switch {
+			case action == netclient.UDP_ACTION_CONNECT:
+				connects.Add(1)
+				response = binary.BigEndian.AppendUint64(response, connID)
+			case binary.BigEndian.Uint64(request[0:8]) != connID:
+				response = binary.BigEndian.AppendUint32(nil, netclient.UDP_ACTION_ERROR)
+				response = append(append(response, txid...), "bad connection id"...)
+			case action == netclient.UDP_ACTION_ANNOUNCE && request[16] == 'x':
+				response = binary.BigEndian.AppendUint32(nil, netclient.UDP_ACTION_ERROR)
+				response = append(append(response, txid...), "unknown torrent"...)
+			case action == netclient.UDP_ACTION_ANNOUNCE:
+				// Interval, leechers, seeders and two peers
+				for _, v := range []uint32{1800, 3, 7} {
+					response = binary.BigEndian.AppendUint32(response, v)
+				}
+				response = append(response, 10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2)
+			case action == netclient.UDP_ACTION_SCRAPE:
+				for i := 16; i < n; i += 20 {
+					for _, v := range []uint32{uint32(request[i]), 5, 1} {
+						response = binary.BigEndian.AppendUint32(response, v)
+					}
+				}
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String() + "/announce"
+}
+
+func TestUDPTracker(t *testing.T) {
+	var connects atomic.Int32
+	tracker, err := netclient.NewUDPTracker(serveUDPTracker(t, &connects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Timeout = 50 * time.Millisecond
+
+	t.Run("Announce after a retransmission", func(t *testing.T) {
+		response, err := tracker.Announce(&netclient.AnnounceRequest{
+			InfoHash: strings.Repeat("a", 20),
+			PeerID:   strings.Repeat("p", 20),
+			Port:     6881,
+			Left:     100,
+			Event:    netclient.EVENT_STARTED,
+			NumWant:  -1,
+		})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		expected := &netclient.UDPAnnounceResponse{
+			Interval: 1800,
+			Leechers: 3,
+			Seeders:  7,
+			Peers:    []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:6881"), netip.MustParseAddrPort("10.0.0.2:6882")},
+		}
+		if !reflect.DeepEqual(response, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, response)
+		}
+	})
+
+	t.Run("Scrape reuses the connection ID", func(t *testing.T) {
+		stats, err := tracker.Scrape([]string{strings.Repeat("\x01", 20), strings.Repeat("\x02", 20)})
+		if err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		expected := []netclient.ScrapeStats{{Seeders: 1, Completed: 5, Leechers: 1}, {Seeders: 2, Completed: 5, Leechers: 1}}
+		if !reflect.DeepEqual(stats, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, stats)
+		}
+		if connects.Load() != 1 {
+			t.Errorf("Expected a single connect, got %d", connects.Load())
+		}
+	})
+
+	t.Run("Tracker error", func(t *testing.T) {
+		_, err := tracker.Announce(&netclient.AnnounceRequest{InfoHash: strings.Repeat("x", 20), PeerID: strings.Repeat("p", 20)})
+		var trackerErr *netclient.UDPTrackerError
+		if !errors.As(err, &trackerErr) || trackerErr.Message != "unknown torrent" {
+			t.Errorf("Expected the tracker's error, got %v", err)
+		}
+	})
+
+	t.Run("Error answering a connect", func(t *testing.T) {
+		refusing, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer refusing.Close()
+		go func() {
+			buff := make([]byte, 2048)
+			n, addr, err := refusing.ReadFrom(buff)
+			if err != nil || n < 16 {
+				return
+			}
+			response := binary.BigEndian.AppendUint32(nil, netclient.UDP_ACTION_ERROR)
+			response = append(append(response, buff[12:16]...), "this tracker is closed for maintenance"...)
+			refusing.WriteTo(response, addr)
+		}()
+		tracker := &netclient.UDPTracker{Host: refusing.LocalAddr().String(), Timeout: time.Second}
+		_, err := tracker.Scrape([]string{strings.Repeat("a", 20)})
+		var trackerErr *netclient.UDPTrackerError
+		if !errors.As(err, &trackerErr) || trackerErr.Message != "this tracker is closed for maintenance" {
+			t.Errorf("Expected the whole error message, got %v", err)
+		}
+	})
+
+	t.Run("A failed request drops the connection ID", func(t *testing.T) {
+		if _, err := tracker.Scrape([]string{strings.Repeat("a", 20)}); err != nil {
+			t.Fatalf("Expected error to be nil, got %v", err)
+		}
+		if connects.Load() != 2 {
+			t.Errorf("Expected a new connect after the error, got %d connects", connects.Load())
+		}
+	})
+
+	t.Run("Give up when the tracker does not answer", func(t *testing.T) {
+		silent, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer silent.Close()
+		tracker := &netclient.UDPTracker{Host: silent.LocalAddr().String(), Timeout: 10 * time.Millisecond, MaxRetries: 2}
+		start := time.Now()
+		if _, err := tracker.Scrape([]string{strings.Repeat("a", 20)}); err == nil {
+			t.Errorf("Expected an error")
+		}
+		// 10 + 20 + 40 milliseconds
+		if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+			t.Errorf("Expected exponential timeouts, gave up after %v", elapsed)
+		}
+	})
+
+	t.Run("Cap the timeout of every attempt", func(t *testing.T) {
+		silent, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer silent.Close()
+		tracker := &netclient.UDPTracker{Host: silent.LocalAddr().String(), Timeout: 20 * time.Millisecond, MaxRetries: 3, MaxTimeout: 20 * time.Millisecond}
+		start := time.Now()
+		if _, err := tracker.Scrape([]string{strings.Repeat("a", 20)}); err == nil {
+			t.Errorf("Expected an error")
+		}
+		// 4 attempts of 20 milliseconds instead of 20 + 40 + 80 + 160
+		if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+			t.Errorf("Expected capped timeouts, gave up after %v", elapsed)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/command"
+	"github.com/codecrafters-io/bittorrent-starter-go/decoder"
+	"github.com/codecrafters-io/bittorrent-starter-go/encoder"
+)
+
+func TestVerify(t *testing.T) {
+	// Three pieces: the first two in a.bin, the last one spanning the end of a.bin and b.txt
+	files := map[string][]byte{
+		"a.bin": make([]byte, 40000),
+		"b.txt": []byte("hello"),
+	}
+	tests := []struct {
+		description string
+		damage      func(root string)
+		statuses    []command.PieceStatus
+	}{
+		{"All pieces complete", func(root string) {}, []command.PieceStatus{command.PIECE_COMPLETE, command.PIECE_COMPLETE, command.PIECE_COMPLETE}},
+		{"Corrupt piece", func(root string) {
+			data := make([]byte, 40000)
+			data[20000] = 1
+			os.WriteFile(filepath.Join(root, "a.bin"), data, 0o644)
+		}, []command.PieceStatus{command.PIECE_COMPLETE, command.PIECE_CORRUPT, command.PIECE_COMPLETE}},
+		{"Missing file", func(root string) {
+			os.Remove(filepath.Join(root, "b.txt"))
+		}, []command.PieceStatus{command.PIECE_COMPLETE, command.PIECE_COMPLETE, command.PIECE_MISSING}},
+		{"Truncated file", func(root string) {
+			os.Truncate(filepath.Join(root, "a.bin"), 10000)
+		}, []command.PieceStatus{command.PIECE_MISSING, command.PIECE_MISSING, command.PIECE_MISSING}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, "data")
+			os.MkdirAll(root, 0o755)
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(root, name), content, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			content, err := (&encoder.MetaInfoBuilder{Announce: "http://tracker/announce"}).Build(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			torrent, _, err := decoder.DecodeTorrentFile(string(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.damage(root)
+
+			report, err := command.Verify(torrent, dir)
+			if err != nil {
+				t.Fatalf("Expected error to be nil, got %v", err)
+			}
+			if len(report.Pieces) != len(test.statuses) {
+				t.Fatalf("Expected %d pieces, got %d", len(test.statuses), len(report.Pieces))
+			}
+			complete := 0
+			for i, piece := range report.Pieces {
+				if piece.Status != test.statuses[i] {
+					t.Errorf("Expected piece %d to be %s, got %s (%s)", i, test.statuses[i], piece.Status, piece.Error)
+				}
+				if piece.Status == command.PIECE_COMPLETE {
+					complete++
+				}
+			}
+			if report.Complete != complete || report.Complete+report.Missing+report.Corrupt != len(report.Pieces) {
+				t.Errorf("Unexpected summary %+v", report)
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package utils
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func ReadFile(file string) (*bytes.Buffer, error) {
@@ -55,3 +58,25 @@ func RandStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+// SafeJoin joins path components under root, rejecting any component that could escape it:
+// empty, "." or ".." components, separators, and absolute or volume names
+func SafeJoin(root string, components ...string) (string, error) {
+	if len(components) == 0 {
+		return "", fmt.Errorf("empty path")
+	}
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." {
+			return "", fmt.Errorf("invalid path component %q", c)
+		}
+		if strings.ContainsAny(c, "/\\\x00") || !filepath.IsLocal(c) {
+			return "", fmt.Errorf("path component %q is not a plain name", c)
+		}
+	}
+	return filepath.Join(append([]string{root}, components...)...), nil
+}
+
+func SHA256Hash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return string(hash[:])
+}